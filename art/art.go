@@ -16,6 +16,7 @@ package art
 
 import (
 	"bytes"
+	"image"
 	"image/color"
 	"image/png"
 	"sync"
@@ -25,6 +26,11 @@ import (
 	"github.com/xStrom/patriot/log"
 )
 
+const (
+	CanvasWidth  = 1000
+	CanvasHeight = 1000
+)
+
 const (
 	White = iota
 	LightGray
@@ -42,41 +48,65 @@ const (
 	DarkBlue
 	LightPurple
 	DarkPurple
-	Transparent
+	Transparent // Not a color of any palette, marks pixels art doesn't care about
 )
 
-var rgbaToColor = map[*color.RGBA64]int{
-	&color.RGBA64{65535, 65535, 65535, 65535}: White,
-	&color.RGBA64{58596, 58596, 58596, 65535}: LightGray,
-	&color.RGBA64{34952, 34952, 34952, 65535}: Gray,
-	&color.RGBA64{8738, 8738, 8738, 65535}:    Black,
-	&color.RGBA64{65535, 42919, 53713, 65535}: Pink,
-	&color.RGBA64{58853, 0, 2313, 65535}:      Red,
-	&color.RGBA64{58853, 38293, 0, 65535}:     Orange,
-	&color.RGBA64{41120, 27242, 16962, 65535}: Brown,
-	&color.RGBA64{58853, 55769, 0, 65535}:     Yellow,
-	&color.RGBA64{38036, 57568, 17476, 65535}: LightGreen,
-	&color.RGBA64{514, 48830, 257, 65535}:     Green,
-	&color.RGBA64{0, 54227, 56797, 65535}:     Cyan,
-	&color.RGBA64{0, 54227, 54227, 65535}:     Cyan,
-	&color.RGBA64{0, 33667, 51143, 65535}:     MediumBlue,
-	&color.RGBA64{0, 0, 60138, 65535}:         DarkBlue,
-	&color.RGBA64{53199, 28270, 58596, 65535}: LightPurple,
-	&color.RGBA64{33410, 0, 32896, 65535}:     DarkPurple,
-}
-
 type Pixel struct {
 	X int
 	Y int
 	C int
 }
 
+// Stored for pixels whose color isn't known
+const unknownColor = 0xff
+
 type Image struct {
 	lock    sync.RWMutex
 	version int
-	colors  map[int]int
+	colors  []uint8 // Row by row, unknownColor where not known
 	width   int
 	height  int
+
+	listenersLock sync.Mutex
+	listeners     []Listener
+}
+
+// Listener gets notified of changes to an Image, after the change has been made
+type Listener interface {
+	PixelChanged(x, y, color, version int)
+	KeyframeChanged(image *Image)
+}
+
+func (i *Image) AddListener(l Listener) {
+	i.listenersLock.Lock()
+	defer i.listenersLock.Unlock()
+	i.listeners = append(i.listeners, l)
+}
+
+func (i *Image) RemoveListener(l Listener) {
+	i.listenersLock.Lock()
+	defer i.listenersLock.Unlock()
+	for j, ll := range i.listeners {
+		if ll == l {
+			i.listeners = append(i.listeners[:j:j], i.listeners[j+1:]...)
+			return
+		}
+	}
+}
+
+func (i *Image) getListeners() []Listener {
+	i.listenersLock.Lock()
+	defer i.listenersLock.Unlock()
+	return i.listeners
+}
+
+// Creates an image from colors row by row, -1 marking unknown colors
+func NewImage(version, width, height int, colors []int) *Image {
+	i := &Image{version: version, width: width, height: height, colors: make([]uint8, len(colors))}
+	for j, c := range colors {
+		i.colors[j] = storedColor(c)
+	}
+	return i
 }
 
 func (i *Image) Dimensions() (int, int) {
@@ -85,17 +115,36 @@ func (i *Image) Dimensions() (int, int) {
 	return i.width, i.height
 }
 
+// Returns a copy of the colors row by row, -1 marking unknown colors
+func (i *Image) Colors() (int, int, []int) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	colors := make([]int, len(i.colors))
+	for j, c := range i.colors {
+		if c == unknownColor {
+			colors[j] = -1
+		} else {
+			colors[j] = int(c)
+		}
+	}
+	return i.width, i.height, colors
+}
+
 func (i *Image) Version() int {
 	i.lock.RLock()
 	defer i.lock.RUnlock()
 	return i.version
 }
 
+// Returns the color at x, y or -1 if it isn't known or is out of bounds
 func (i *Image) At(x, y int) int {
 	i.lock.RLock()
 	defer i.lock.RUnlock()
-	if c, ok := i.colors[x|(y<<16)]; ok {
-		return c
+	if x < 0 || x >= i.width || y < 0 || y >= i.height {
+		return -1
+	}
+	if c := i.colors[x+y*i.width]; c != unknownColor {
+		return int(c)
 	}
 	return -1
 }
@@ -111,32 +160,17 @@ func (i *Image) ParseKeyframe(version int, data []byte, resource bool) error {
 	minY := img.Bounds().Min.Y
 	maxY := img.Bounds().Max.Y
 	if !resource {
-		if minX != 0 || minY != 0 || maxX != 1000 || maxY != 1000 {
+		if minX != 0 || minY != 0 || maxX != CanvasWidth || maxY != CanvasHeight {
 			return errors.New("Unexpected image bounds")
 		}
 	}
-	// Convert colors
-	colors := make(map[int]int, (maxX-minX)*(maxY-minY))
-	for x := minX; x < maxX; x++ {
-		for y := minY; y < maxY; y++ {
-			coords := x | (y << 16)
-			c := img.At(x, y)
-			color := -1
-			if isTransparent(c) {
-				color = Transparent
-			} else {
-				for rgba, cc := range rgbaToColor {
-					if sameColor(c, rgba) {
-						color = cc
-						break
-					}
-				}
-			}
-			colors[coords] = color
-			if color == -1 {
-				r, g, b, a := c.RGBA()
-				log.Infof("Unknown color in keyframe: %v:%v - %v,%v,%v,%v", x, y, r, g, b, a)
-			}
+	width := maxX - minX
+	colors := convertColors(img)
+	for j, c := range colors {
+		if c == unknownColor {
+			x, y := minX+j%width, minY+j/width
+			r, g, b, a := img.At(x, y).RGBA()
+			log.Infof("Unknown color in keyframe: %v:%v - %v,%v,%v,%v", x, y, r, g, b, a)
 		}
 	}
 	i.lock.Lock()
@@ -145,9 +179,12 @@ func (i *Image) ParseKeyframe(version int, data []byte, resource bool) error {
 	}
 	i.version = version
 	i.colors = colors
-	i.width = maxX - minX
+	i.width = width
 	i.height = maxY - minY
 	i.lock.Unlock()
+	for _, l := range i.getListeners() {
+		l.KeyframeChanged(i)
+	}
 	return nil
 }
 
@@ -156,18 +193,111 @@ func (i *Image) UpdatePixel(x, y, color, version int) {
 	if i.version > version {
 		log.Infof("New pixel version is old! %v > %v", i.version, version)
 	}
+	if x < 0 || x >= i.width || y < 0 || y >= i.height || color < 0 || color >= unknownColor {
+		i.lock.Unlock()
+		log.Infof("Ignoring invalid pixel update: %v:%v - %v", x, y, color)
+		return
+	}
 	i.version = version
-	i.colors[x|(y<<16)] = color
+	i.colors[x+y*i.width] = uint8(color)
 	i.lock.Unlock()
+	for _, l := range i.getListeners() {
+		l.PixelChanged(x, y, color, version)
+	}
+}
+
+// Renders the image with the palette, Transparent and unknown pixels become fully transparent
+func (i *Image) Paletted(p *Palette) *image.Paletted {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	cp := append(p.ColorPalette(), color.NRGBA{})
+	transparent := uint8(len(cp) - 1)
+	img := image.NewPaletted(image.Rect(0, 0, i.width, i.height), cp)
+	for j, c := range i.colors {
+		if int(c) < p.Len() {
+			img.Pix[j] = c
+		} else {
+			img.Pix[j] = transparent
+		}
+	}
+	return img
+}
+
+// Converts the image into colors row by row, with fast paths for the usual png formats
+func convertColors(img image.Image) []uint8 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	colors := make([]uint8, w*h)
+	switch src := img.(type) {
+	case *image.Paletted:
+		// Every palette entry only needs to be looked up once
+		var lut [256]uint8
+		for j := range lut {
+			lut[j] = unknownColor
+		}
+		for j, c := range src.Palette {
+			lut[j] = storedColor(lookupColor(c))
+		}
+		for y := 0; y < h; y++ {
+			row := src.Pix[y*src.Stride : y*src.Stride+w]
+			for x, ci := range row {
+				colors[x+y*w] = lut[ci]
+			}
+		}
+	case *image.NRGBA:
+		cache := map[color.NRGBA]uint8{}
+		for y := 0; y < h; y++ {
+			row := src.Pix[y*src.Stride : y*src.Stride+4*w]
+			for x := 0; x < w; x++ {
+				c := color.NRGBA{row[4*x], row[4*x+1], row[4*x+2], row[4*x+3]}
+				cc, ok := cache[c]
+				if !ok {
+					cc = storedColor(lookupColor(c))
+					cache[c] = cc
+				}
+				colors[x+y*w] = cc
+			}
+		}
+	case *image.RGBA:
+		cache := map[color.RGBA]uint8{}
+		for y := 0; y < h; y++ {
+			row := src.Pix[y*src.Stride : y*src.Stride+4*w]
+			for x := 0; x < w; x++ {
+				c := color.RGBA{row[4*x], row[4*x+1], row[4*x+2], row[4*x+3]}
+				cc, ok := cache[c]
+				if !ok {
+					cc = storedColor(lookupColor(c))
+					cache[c] = cc
+				}
+				colors[x+y*w] = cc
+			}
+		}
+	default:
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				colors[x+y*w] = storedColor(lookupColor(img.At(b.Min.X+x, b.Min.Y+y)))
+			}
+		}
+	}
+	return colors
+}
+
+// Returns the color of the current palette matching c exactly, or -1 if there is none
+func lookupColor(c color.Color) int {
+	if isTransparent(c) {
+		return Transparent
+	}
+	return CurrentPalette().Index(c)
+}
+
+func storedColor(c int) uint8 {
+	if c < 0 {
+		return unknownColor
+	}
+	return uint8(c)
 }
 
 func isTransparent(c color.Color) bool {
 	r, g, b, a := c.RGBA()
 	return a != 65535 && r == g && r == b && r == a
 }
-
-func sameColor(c1, c2 color.Color) bool {
-	r1, g1, b1, a1 := c1.RGBA()
-	r2, g2, b2, a2 := c2.RGBA()
-	return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
-}
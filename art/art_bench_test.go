@@ -0,0 +1,168 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package art
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// Returns a canvas keyframe of random colors encoded the way the server does, as a paletted png
+func benchKeyframe(b *testing.B) []byte {
+	img := image.NewPaletted(image.Rect(0, 0, CanvasWidth, CanvasHeight), ColorPalette())
+	rnd := rand.New(rand.NewSource(1))
+	for j := range img.Pix {
+		img.Pix[j] = uint8(rnd.Intn(len(img.Palette)))
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// mapImage stores colors the way Image did before the dense slice, as the baseline for the benchmarks
+type mapImage struct {
+	lock    sync.RWMutex
+	version int
+	colors  map[int]int
+}
+
+func (i *mapImage) At(x, y int) int {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	if c, ok := i.colors[x|(y<<16)]; ok {
+		return c
+	}
+	return -1
+}
+
+func (i *mapImage) ParseKeyframe(version int, data []byte) error {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	b := img.Bounds()
+	colors := make(map[int]int, b.Dx()*b.Dy())
+	for x := b.Min.X; x < b.Max.X; x++ {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			colors[x|(y<<16)] = lookupColor(img.At(x, y))
+		}
+	}
+	i.lock.Lock()
+	i.version = version
+	i.colors = colors
+	i.lock.Unlock()
+	return nil
+}
+
+func (i *mapImage) UpdatePixel(x, y, color, version int) {
+	i.lock.Lock()
+	i.version = version
+	i.colors[x|(y<<16)] = color
+	i.lock.Unlock()
+}
+
+// The operations benchmarked on both Image and mapImage
+type benchImage interface {
+	At(x, y int) int
+	UpdatePixel(x, y, color, version int)
+}
+
+// Runs f as a sub-benchmark for Image and for the mapImage baseline, both with the keyframe parsed
+func benchImages(b *testing.B, f func(b *testing.B, img benchImage)) {
+	data := benchKeyframe(b)
+	dense := &Image{}
+	if err := dense.ParseKeyframe(1, data, false); err != nil {
+		b.Fatal(err)
+	}
+	m := &mapImage{}
+	if err := m.ParseKeyframe(1, data); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("dense", func(b *testing.B) {
+		b.ReportAllocs()
+		f(b, dense)
+	})
+	b.Run("map", func(b *testing.B) {
+		b.ReportAllocs()
+		f(b, m)
+	})
+}
+
+// The allocations show how much memory a parsed keyframe takes
+func BenchmarkParseKeyframe(b *testing.B) {
+	data := benchKeyframe(b)
+	b.Run("dense", func(b *testing.B) {
+		img := &Image{}
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if err := img.ParseKeyframe(i+1, data, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("map", func(b *testing.B) {
+		img := &mapImage{}
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if err := img.ParseKeyframe(i+1, data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkAt(b *testing.B) {
+	benchImages(b, func(b *testing.B, img benchImage) {
+		sum := 0
+		for i := 0; i < b.N; i++ {
+			sum += img.At(i%CanvasWidth, (i/CanvasWidth)%CanvasHeight)
+		}
+		if sum < 0 {
+			b.Fatal("Unknown colors in the keyframe")
+		}
+	})
+}
+
+func BenchmarkUpdatePixel(b *testing.B) {
+	// Versions have to keep growing across runs of the same image
+	version := 1
+	benchImages(b, func(b *testing.B, img benchImage) {
+		for i := 0; i < b.N; i++ {
+			version++
+			img.UpdatePixel(i%CanvasWidth, (i/CanvasWidth)%CanvasHeight, i%(DarkPurple+1), version)
+		}
+	})
+}
+
+func BenchmarkPaletted(b *testing.B) {
+	img := &Image{}
+	if err := img.ParseKeyframe(1, benchKeyframe(b), false); err != nil {
+		b.Fatal(err)
+	}
+	p := CurrentPalette()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		img.Paletted(p)
+	}
+}
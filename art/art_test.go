@@ -0,0 +1,104 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package art
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// Hides the type of the image so that convertColors takes the generic path
+type genericImage struct {
+	image.Image
+}
+
+// Returns the same random image as paletted, NRGBA and RGBA, with Transparent and an unknown color among the canvas colors
+func testImages() []image.Image {
+	p := append(ColorPalette(), color.NRGBA{}, color.NRGBA{1, 2, 3, 0xff})
+	paletted := image.NewPaletted(image.Rect(0, 0, 40, 30), p)
+	rnd := rand.New(rand.NewSource(1))
+	for j := range paletted.Pix {
+		paletted.Pix[j] = uint8(rnd.Intn(len(p)))
+	}
+	nrgba := image.NewNRGBA(paletted.Bounds())
+	draw.Draw(nrgba, nrgba.Bounds(), paletted, image.Point{}, draw.Src)
+	rgba := image.NewRGBA(paletted.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), paletted, image.Point{}, draw.Src)
+	return []image.Image{paletted, nrgba, rgba}
+}
+
+func TestConvertColorsFastPaths(t *testing.T) {
+	for _, img := range testImages() {
+		// A sub image doesn't start at the origin and its rows are shorter than the stride
+		sub := img.(interface {
+			SubImage(image.Rectangle) image.Image
+		}).SubImage(image.Rect(5, 7, 33, 25))
+		for _, img := range []image.Image{img, sub} {
+			want := convertColors(genericImage{img})
+			got := convertColors(img)
+			if len(got) != len(want) {
+				t.Fatalf("%T %v: got %v colors, want %v", img, img.Bounds(), len(got), len(want))
+			}
+			for j := range want {
+				if got[j] != want[j] {
+					t.Errorf("%T %v: color %v is %v, want %v", img, img.Bounds(), j, got[j], want[j])
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestParseKeyframeFormats(t *testing.T) {
+	var want []int
+	for _, img := range testImages() {
+		buf := &bytes.Buffer{}
+		if err := png.Encode(buf, img); err != nil {
+			t.Fatal(err)
+		}
+		i := &Image{}
+		if err := i.ParseKeyframe(1, buf.Bytes(), true); err != nil {
+			t.Fatal(err)
+		}
+		_, _, colors := i.Colors()
+		if want == nil {
+			want = colors
+			continue
+		}
+		for j := range want {
+			if colors[j] != want[j] {
+				t.Errorf("%T: color %v is %v, want %v", img, j, colors[j], want[j])
+				break
+			}
+		}
+	}
+	unknown, transparent := 0, 0
+	for _, c := range want {
+		switch c {
+		case -1:
+			unknown++
+		case Transparent:
+			transparent++
+		}
+	}
+	if unknown == 0 || transparent == 0 {
+		t.Errorf("Got %v unknown and %v transparent pixels, want some of both", unknown, transparent)
+	}
+}
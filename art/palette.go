@@ -0,0 +1,373 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package art
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// The realtime protocol has 4 bits for the color
+const MaxPaletteColors = 16
+
+// Palette is the set of colors a canvas can be drawn with.
+// The first color is assumed to be the color of a blank canvas.
+type Palette struct {
+	names   []string
+	colors  []color.NRGBA
+	indexes map[color.NRGBA]int // Including aliases, other values accepted as a color
+}
+
+// Creates a palette of the colors in order, empty names become the hex value of the color
+func NewPalette(names []string, colors []color.NRGBA) (*Palette, error) {
+	if len(colors) == 0 || len(colors) > MaxPaletteColors {
+		return nil, errors.Errorf("Palette needs 1 to %v colors, got %v", MaxPaletteColors, len(colors))
+	}
+	p := &Palette{
+		names:   make([]string, len(colors)),
+		colors:  make([]color.NRGBA, len(colors)),
+		indexes: map[color.NRGBA]int{},
+	}
+	copy(p.colors, colors)
+	for i, c := range colors {
+		if i < len(names) && names[i] != "" {
+			p.names[i] = names[i]
+		} else {
+			p.names[i] = hexColor(c)
+		}
+		if _, ok := p.indexes[c]; ok {
+			return nil, errors.Errorf("Palette has %v more than once", hexColor(c))
+		}
+		p.indexes[c] = i
+	}
+	for i, name := range p.names {
+		if j, ok := p.ByName(name); ok && j != i {
+			return nil, errors.Errorf("Palette has the name %v more than once", name)
+		}
+	}
+	return p, nil
+}
+
+// Makes c another accepted value of the color i
+func (p *Palette) AddAlias(i int, c color.NRGBA) error {
+	if i < 0 || i >= len(p.colors) {
+		return errors.Errorf("No color %v in palette", i)
+	}
+	if j, ok := p.indexes[c]; ok && j != i {
+		return errors.Errorf("%v is already %v", hexColor(c), p.names[j])
+	}
+	p.indexes[c] = i
+	return nil
+}
+
+func (p *Palette) Len() int {
+	return len(p.colors)
+}
+
+func (p *Palette) Name(i int) string {
+	if i == Transparent {
+		return "Transparent"
+	}
+	if i < 0 || i >= len(p.names) {
+		return strconv.Itoa(i)
+	}
+	return p.names[i]
+}
+
+func (p *Palette) Color(i int) color.NRGBA {
+	return p.colors[i]
+}
+
+// Returns the color exactly matching c, or -1 if there is none
+func (p *Palette) Index(c color.Color) int {
+	if i, ok := p.indexes[color.NRGBAModel.Convert(c).(color.NRGBA)]; ok {
+		return i
+	}
+	return -1
+}
+
+// Returns the color with the given case insensitive name or number, e.g. LightGray or 1
+func (p *Palette) ByName(name string) (int, bool) {
+	if strings.EqualFold(name, "transparent") {
+		return Transparent, true
+	}
+	for i, n := range p.names {
+		if strings.EqualFold(n, name) {
+			return i, true
+		}
+	}
+	if i, err := strconv.Atoi(name); err == nil && i >= 0 && i < len(p.colors) {
+		return i, true
+	}
+	return -1, false
+}
+
+// Returns the colors as a color.Palette, indexed by color
+func (p *Palette) ColorPalette() color.Palette {
+	cp := make(color.Palette, len(p.colors))
+	for i, c := range p.colors {
+		cp[i] = c
+	}
+	return cp
+}
+
+var DefaultPalette = mustPalette(
+	[]string{"White", "LightGray", "Gray", "Black", "Pink", "Red", "Orange", "Brown", "Yellow", "LightGreen", "Green", "Cyan", "MediumBlue", "DarkBlue", "LightPurple", "DarkPurple"},
+	[]color.NRGBA{
+		White:       {0xff, 0xff, 0xff, 0xff},
+		LightGray:   {0xe4, 0xe4, 0xe4, 0xff},
+		Gray:        {0x88, 0x88, 0x88, 0xff},
+		Black:       {0x22, 0x22, 0x22, 0xff},
+		Pink:        {0xff, 0xa7, 0xd1, 0xff},
+		Red:         {0xe5, 0x00, 0x09, 0xff},
+		Orange:      {0xe5, 0x95, 0x00, 0xff},
+		Brown:       {0xa0, 0x6a, 0x42, 0xff},
+		Yellow:      {0xe5, 0xd9, 0x00, 0xff},
+		LightGreen:  {0x94, 0xe0, 0x44, 0xff},
+		Green:       {0x02, 0xbe, 0x01, 0xff},
+		Cyan:        {0x00, 0xd3, 0xdd, 0xff},
+		MediumBlue:  {0x00, 0x83, 0xc7, 0xff},
+		DarkBlue:    {0x00, 0x00, 0xea, 0xff},
+		LightPurple: {0xcf, 0x6e, 0xe4, 0xff},
+		DarkPurple:  {0x82, 0x00, 0x80, 0xff},
+	},
+	map[int]color.NRGBA{
+		Cyan: {0x00, 0xd3, 0xd3, 0xff},
+	},
+)
+
+func mustPalette(names []string, colors []color.NRGBA, aliases map[int]color.NRGBA) *Palette {
+	p, err := NewPalette(names, colors)
+	if err != nil {
+		panic(err)
+	}
+	for i, c := range aliases {
+		if err := p.AddAlias(i, c); err != nil {
+			panic(err)
+		}
+	}
+	return p
+}
+
+func hexColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func parseHex(s string) (color.NRGBA, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || len(s) != 6 {
+		return color.NRGBA{}, errors.Errorf("Invalid hex color %q", s)
+	}
+	return color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
+
+var paletteLock sync.RWMutex
+var currentPalette = DefaultPalette
+
+// Sets the palette used for parsing keyframes and resources
+func UsePalette(p *Palette) {
+	paletteLock.Lock()
+	defer paletteLock.Unlock()
+	currentPalette = p
+}
+
+func CurrentPalette() *Palette {
+	paletteLock.RLock()
+	defer paletteLock.RUnlock()
+	return currentPalette
+}
+
+// Returns the color of the current palette with the given name, see Palette.ByName
+func ColorByName(name string) (int, bool) {
+	return CurrentPalette().ByName(name)
+}
+
+// Returns the current palette as a color.Palette, indexed by color
+func ColorPalette() color.Palette {
+	return CurrentPalette().ColorPalette()
+}
+
+// Loads a palette, the format is picked by extension: .act (Adobe), .gpl (GIMP), .json or anything else as a hex list
+func LoadPalette(path string) (*Palette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read palette")
+	}
+	var p *Palette
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".act":
+		p, err = ParseACT(data)
+	case ".gpl":
+		p, err = ParseGPL(data)
+	case ".json":
+		p, err = ParseJSONPalette(data)
+	default:
+		p, err = ParseHexPalette(data)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse palette %v", path)
+	}
+	return p, nil
+}
+
+// Parses an Adobe Color Table, 256 RGB triplets followed by the color count and transparent index
+func ParseACT(data []byte) (*Palette, error) {
+	if len(data) != 768 && len(data) != 772 {
+		return nil, errors.Errorf("ACT should be 768 or 772 bytes, got %v", len(data))
+	}
+	if len(data) == 768 {
+		// Unused entries can't be told apart from black, so all 256 would be colors
+		return nil, errors.New("ACT has no color count, save it with the number of colors")
+	}
+	count := int(binary.BigEndian.Uint16(data[768:]))
+	if count > 256 {
+		return nil, errors.Errorf("ACT has an invalid color count %v", count)
+	}
+	colors := make([]color.NRGBA, count)
+	for i := range colors {
+		colors[i] = color.NRGBA{data[3*i], data[3*i+1], data[3*i+2], 0xff}
+	}
+	return newImportedPalette(nil, colors)
+}
+
+// Parses a GIMP palette
+func ParseGPL(data []byte) (*Palette, error) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	if !s.Scan() || strings.TrimSpace(s.Text()) != "GIMP Palette" {
+		return nil, errors.New("Missing GIMP Palette header")
+	}
+	var names []string
+	var colors []color.NRGBA
+	for line := 2; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "Name:") || strings.HasPrefix(text, "Columns:") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 3 {
+			return nil, errors.Errorf("Line %v: expected R G B [name]", line)
+		}
+		var rgb [3]uint8
+		for i := range rgb {
+			v, err := strconv.ParseUint(fields[i], 10, 8)
+			if err != nil {
+				return nil, errors.Errorf("Line %v: invalid color component %q", line, fields[i])
+			}
+			rgb[i] = uint8(v)
+		}
+		colors = append(colors, color.NRGBA{rgb[0], rgb[1], rgb[2], 0xff})
+		names = append(names, strings.Join(fields[3:], ""))
+	}
+	return newImportedPalette(names, colors)
+}
+
+// Parses a JSON palette, a list of {"name": "White", "hex": "#ffffff", "aliases": ["#fefefe"]} in color order
+func ParseJSONPalette(data []byte) (*Palette, error) {
+	var entries []struct {
+		Name    string   `json:"name"`
+		Hex     string   `json:"hex"`
+		Aliases []string `json:"aliases"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	colors := make([]color.NRGBA, len(entries))
+	for i, e := range entries {
+		c, err := parseHex(e.Hex)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Color %v", i)
+		}
+		names[i], colors[i] = e.Name, c
+	}
+	p, err := NewPalette(names, colors)
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range entries {
+		for _, a := range e.Aliases {
+			c, err := parseHex(a)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Color %v alias", i)
+			}
+			if err := p.AddAlias(i, c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p, nil
+}
+
+// Parses a list of hex colors, one per line with an optional name after it. Lines starting with ; are comments.
+func ParseHexPalette(data []byte) (*Palette, error) {
+	var names []string
+	var colors []color.NRGBA
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		c, err := parseHex(fields[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Line %v", line)
+		}
+		colors = append(colors, c)
+		names = append(names, strings.Join(fields[1:], ""))
+	}
+	return newImportedPalette(names, colors)
+}
+
+// Imported palettes know nothing but the colors, so colors that are one of the default colors
+// get its name and aliases, e.g. design/colors.act only has one of the two Cyan values the server uses
+func newImportedPalette(names []string, colors []color.NRGBA) (*Palette, error) {
+	named := make([]string, len(colors))
+	copy(named, names)
+	for i, c := range colors {
+		if d, ok := DefaultPalette.indexes[c]; ok && named[i] == "" {
+			named[i] = DefaultPalette.names[d]
+		}
+	}
+	p, err := NewPalette(named, colors)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range colors {
+		d, ok := DefaultPalette.indexes[c]
+		if !ok {
+			continue
+		}
+		for alias, dd := range DefaultPalette.indexes {
+			if dd == d {
+				if _, taken := p.indexes[alias]; !taken {
+					p.indexes[alias] = i
+				}
+			}
+		}
+	}
+	return p, nil
+}
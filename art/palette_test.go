@@ -0,0 +1,117 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package art
+
+import (
+	"encoding/binary"
+	"image/color"
+	"testing"
+)
+
+// Encodes an Adobe Color Table, without the color count if count is negative
+func act(count int, colors ...color.NRGBA) []byte {
+	data := make([]byte, 768, 772)
+	for i, c := range colors {
+		data[3*i], data[3*i+1], data[3*i+2] = c.R, c.G, c.B
+	}
+	if count >= 0 {
+		data = data[:772]
+		binary.BigEndian.PutUint16(data[768:], uint16(count))
+		binary.BigEndian.PutUint16(data[770:], 0xffff)
+	}
+	return data
+}
+
+func TestParsePalette(t *testing.T) {
+	white := color.NRGBA{0xff, 0xff, 0xff, 0xff}
+	red := color.NRGBA{0xe5, 0x00, 0x00, 0xff}
+	black := color.NRGBA{0x00, 0x00, 0x00, 0xff}
+	tests := []struct {
+		name  string
+		parse func([]byte) (*Palette, error)
+		data  []byte
+		want  []string // Names and hex values of the colors, nil if parsing should fail
+	}{
+		{"act", ParseACT, act(3, white, red, black), []string{"White #ffffff", "#e50000 #e50000", "#000000 #000000"}},
+		{"act with a single black color", ParseACT, act(1, black), []string{"#000000 #000000"}},
+		{"act without a count", ParseACT, act(-1, white, red), nil},
+		{"act of the wrong size", ParseACT, act(3, white, red, black)[:770], nil},
+		{"act with too many colors", ParseACT, act(257, white), nil},
+		{"act with no colors", ParseACT, act(0), nil},
+		{"act with a color twice", ParseACT, act(2, red, red), nil},
+
+		{"gpl", ParseGPL, []byte("GIMP Palette\nName: Test\nColumns: 4\n# Comment\n255 255 255 Snow\n229 0 0\n  0   0   0 Deep Black\n"),
+			[]string{"Snow #ffffff", "#e50000 #e50000", "DeepBlack #000000"}},
+		{"gpl without a header", ParseGPL, []byte("255 255 255 White\n"), nil},
+		{"gpl with a missing component", ParseGPL, []byte("GIMP Palette\n255 255\n"), nil},
+		{"gpl with a component out of range", ParseGPL, []byte("GIMP Palette\n256 0 0\n"), nil},
+		{"gpl with no colors", ParseGPL, []byte("GIMP Palette\n"), nil},
+
+		{"json", ParseJSONPalette, []byte(`[{"name": "Snow", "hex": "#ffffff", "aliases": ["#fefefe"]}, {"hex": "000000"}]`),
+			[]string{"Snow #ffffff", "#000000 #000000"}},
+		{"json with a syntax error", ParseJSONPalette, []byte(`[{"hex": "#ffffff"}`), nil},
+		{"json with an invalid color", ParseJSONPalette, []byte(`[{"hex": "#fffff"}]`), nil},
+		{"json with an invalid alias", ParseJSONPalette, []byte(`[{"hex": "#ffffff", "aliases": ["white"]}]`), nil},
+		{"json with the same name twice", ParseJSONPalette, []byte(`[{"name": "A", "hex": "#ffffff"}, {"name": "A", "hex": "#000000"}]`), nil},
+
+		{"hex", ParseHexPalette, []byte("; Comment\n#ffffff\ne50000 Fire\n\n#000000\n"), []string{"White #ffffff", "Fire #e50000", "#000000 #000000"}},
+		{"hex with an invalid color", ParseHexPalette, []byte("#ffffff\n#00000g\n"), nil},
+		{"hex with no colors", ParseHexPalette, []byte("; Nothing\n"), nil},
+		{"hex with too many colors", ParseHexPalette, []byte("#000000\n#000001\n#000002\n#000003\n#000004\n#000005\n#000006\n#000007\n" +
+			"#000008\n#000009\n#00000a\n#00000b\n#00000c\n#00000d\n#00000e\n#00000f\n#000010\n"), nil},
+	}
+	for _, tt := range tests {
+		p, err := tt.parse(tt.data)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%v: parsed %v colors, want an error", tt.name, p.Len())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for i := 0; i < p.Len(); i++ {
+			got = append(got, p.Name(i)+" "+hexColor(p.Color(i)))
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%v: got %q, want %q", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%v: got %q, want %q", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestLoadPaletteACT(t *testing.T) {
+	p, err := LoadPalette("../design/colors.act")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != DefaultPalette.Len() {
+		t.Fatalf("Loaded %v colors, want %v", p.Len(), DefaultPalette.Len())
+	}
+	for i := 0; i < p.Len(); i++ {
+		if p.Name(i) != DefaultPalette.Name(i) {
+			t.Errorf("Color %v is %v, want %v", i, p.Name(i), DefaultPalette.Name(i))
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quantize
+
+import (
+	"image"
+	"image/draw"
+	"image/gif"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/xStrom/patriot/art"
+)
+
+// Loads every frame of an animated gif the way it's shown, quantized to the current palette, along with how long each is shown
+func LoadGIF(path, mode string) ([]*art.Image, []time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to open image")
+	}
+	defer f.Close()
+	g, err := gif.DecodeAll(f)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to decode gif")
+	}
+	// Frames only hold what changed, so they're drawn over each other like a viewer would
+	screen := image.NewNRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	frames := make([]*art.Image, 0, len(g.Image))
+	delays := make([]time.Duration, 0, len(g.Image))
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(screen.Rect)
+			copy(previous.Pix, screen.Pix)
+		}
+		draw.Draw(screen, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		img, err := Quantize(screen, art.CurrentPalette(), mode)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Failed to quantize frame %v", i)
+		}
+		frames = append(frames, img)
+		delays = append(delays, time.Duration(g.Delay[i])*10*time.Millisecond)
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(screen, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			screen = previous
+		}
+	}
+	return frames, delays, nil
+}
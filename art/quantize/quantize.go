@@ -0,0 +1,205 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package quantize maps arbitrary images to the colors of a palette
+package quantize
+
+import (
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/log"
+)
+
+// How off-palette colors are handled
+const (
+	Exact          = "exact"           // Unknown colors are logged and left unknown
+	Strict         = "strict"          // Unknown colors are an error
+	Nearest        = "nearest"         // Closest palette color in Lab space
+	FloydSteinberg = "floyd-steinberg" // Closest color, diffusing the error to neighbours
+	Ordered        = "ordered"         // Closest color after a 4x4 Bayer threshold
+)
+
+func ValidMode(mode string) bool {
+	switch mode {
+	case Exact, Strict, Nearest, FloydSteinberg, Ordered:
+		return true
+	}
+	return false
+}
+
+// Pixels less opaque than this become art.Transparent
+const alphaThreshold = 0x8000
+
+// Strength of ordered dithering, in 8-bit color steps
+const orderedSpread = 32
+
+var bayer4 = [4][4]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// Loads a png, gif or jpeg and quantizes it to the current palette
+func Load(path, mode string) (*art.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to open image")
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to decode image")
+	}
+	return Quantize(img, art.CurrentPalette(), mode)
+}
+
+// Maps every pixel of img to a color of the palette
+func Quantize(img image.Image, p *art.Palette, mode string) (*art.Image, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	colors := make([]int, w*h)
+
+	labs := make([]lab, p.Len())
+	for i := range labs {
+		c := p.Color(i)
+		labs[i] = toLab(float64(c.R), float64(c.G), float64(c.B))
+	}
+
+	// Error diffusion buffers for the current and next row, in 8-bit RGB
+	errCur := make([][3]float64, w+2)
+	errNext := make([][3]float64, w+2)
+
+	unknown := 0
+	var firstX, firstY int
+	var firstColor color.Color
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.At(b.Min.X+x, b.Min.Y+y)
+			_, _, _, a := c.RGBA()
+			if a < alphaThreshold {
+				colors[x+y*w] = art.Transparent
+				continue
+			}
+			if mode == Exact || mode == Strict {
+				i := p.Index(c)
+				if i < 0 {
+					if unknown == 0 {
+						firstX, firstY, firstColor = x, y, c
+					}
+					unknown++
+				}
+				colors[x+y*w] = i
+				continue
+			}
+			if i := p.Index(c); i >= 0 && mode == Ordered {
+				// Art that's already on palette shouldn't get noisy
+				colors[x+y*w] = i
+				continue
+			}
+			n := color.NRGBAModel.Convert(c).(color.NRGBA)
+			rgb := [3]float64{float64(n.R), float64(n.G), float64(n.B)}
+			switch mode {
+			case FloydSteinberg:
+				for k := range rgb {
+					rgb[k] = clamp(rgb[k] + errCur[x+1][k])
+				}
+			case Ordered:
+				t := (bayer4[y%4][x%4]+0.5)/16 - 0.5
+				for k := range rgb {
+					rgb[k] = clamp(rgb[k] + t*orderedSpread)
+				}
+			}
+			i := nearest(labs, toLab(rgb[0], rgb[1], rgb[2]))
+			colors[x+y*w] = i
+			if mode == FloydSteinberg {
+				pc := p.Color(i)
+				got := [3]float64{float64(pc.R), float64(pc.G), float64(pc.B)}
+				for k := range rgb {
+					e := rgb[k] - got[k]
+					errCur[x+2][k] += e * 7 / 16
+					errNext[x][k] += e * 3 / 16
+					errNext[x+1][k] += e * 5 / 16
+					errNext[x+2][k] += e * 1 / 16
+				}
+			}
+		}
+		errCur, errNext = errNext, errCur
+		for k := range errNext {
+			errNext[k] = [3]float64{}
+		}
+	}
+
+	if unknown > 0 {
+		r, g, bb, a := firstColor.RGBA()
+		if mode == Strict {
+			return nil, errors.Errorf("%v pixels are not palette colors, the first at %v:%v - %v,%v,%v,%v", unknown, firstX, firstY, r, g, bb, a)
+		}
+		log.Infof("%v pixels are not palette colors, the first at %v:%v - %v,%v,%v,%v", unknown, firstX, firstY, r, g, bb, a)
+	}
+	return art.NewImage(1, w, h, colors), nil
+}
+
+type lab struct {
+	l, a, b float64
+}
+
+func nearest(labs []lab, c lab) int {
+	best, bestDist := 0, math.Inf(1)
+	for i, l := range labs {
+		d := (l.l-c.l)*(l.l-c.l) + (l.a-c.a)*(l.a-c.a) + (l.b-c.b)*(l.b-c.b)
+		if d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// Converts 8-bit sRGB to CIE Lab under D65
+func toLab(r, g, b float64) lab {
+	lr, lg, lb := linear(r), linear(g), linear(b)
+	x := (0.4124*lr + 0.3576*lg + 0.1805*lb) / 0.95047
+	y := 0.2126*lr + 0.7152*lg + 0.0722*lb
+	z := (0.0193*lr + 0.1192*lg + 0.9505*lb) / 1.08883
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return lab{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+func linear(v float64) float64 {
+	v /= 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func labF(t float64) float64 {
+	if t > 216.0/24389 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27*t + 16) / 116
+}
+
+func clamp(v float64) float64 {
+	return math.Max(0, math.Min(255, v))
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resource
+
+import (
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+
+	"github.com/xStrom/patriot/art"
+)
+
+// Sets which colors besides the target are good enough for each pixel, must be called before Watch.
+// The rules map a target color to the colors that may stand in for it everywhere in the art.
+// Where the alternates png of the art's size has a palette color, that color and the colors the rules allow for it are accepted too.
+func (r *Resource) SetAccept(rules map[int][]int, alternatesPath string) error {
+	var alternates *art.Image
+	if alternatesPath != "" {
+		data, err := ioutil.ReadFile(alternatesPath)
+		if err != nil {
+			return errors.Wrap(err, "Failed to read alternates")
+		}
+		alternates = &art.Image{}
+		if err := alternates.ParseKeyframe(1, data, true); err != nil {
+			return errors.Wrap(err, "Failed to parse alternates")
+		}
+		w, h := alternates.Dimensions()
+		if w != r.x1-r.x0+1 || h != r.y1-r.y0+1 {
+			return errors.Errorf("Alternates are %vx%v but the art is %vx%v", w, h, r.x1-r.x0+1, r.y1-r.y0+1)
+		}
+	}
+	group := func(c int) uint32 {
+		if c < 0 || c >= art.Transparent {
+			return 0
+		}
+		set := uint32(1) << uint(c)
+		for _, a := range rules[c] {
+			set |= 1 << uint(a)
+		}
+		return set
+	}
+	accept := map[int]uint32{}
+	for _, coords := range r.coords() {
+		x, y := coords&0xffff, coords>>16
+		set := group(r.target(x, y))
+		if alternates != nil {
+			set |= group(alternates.At(x-r.x0, y-r.y0))
+		}
+		if set != 0 {
+			accept[coords] = set
+		}
+	}
+	r.lock.Lock()
+	r.accept = accept
+	r.lock.Unlock()
+	return nil
+}
+
+// Returns the set of colors accepted at the canvas coordinates as a bitmask, 0 if the art doesn't care or the color isn't known
+func (r *Resource) Acceptable(x, y int) uint32 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.acceptable(x, y)
+}
+
+func (r *Resource) acceptable(x, y int) uint32 {
+	if set, ok := r.accept[x|(y<<16)]; ok {
+		return set
+	}
+	if t := r.target(x, y); t >= 0 && t < art.Transparent {
+		return 1 << uint(t)
+	}
+	return 0
+}
+
+// Whether the color at the canvas coordinates is good enough for the art
+func (r *Resource) satisfied(x, y, c int) bool {
+	t := r.target(x, y)
+	if c == t {
+		return true
+	}
+	return c >= 0 && c < art.Transparent && r.acceptable(x, y)&(1<<uint(c)) != 0
+}
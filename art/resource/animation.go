@@ -0,0 +1,94 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resource
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xStrom/patriot/art"
+)
+
+// Animation cycles through frames of art at the same spot.
+// A frame is drawn until enough of it is complete, shown for its dwell time and then the next frame takes over.
+// With a timeout the next frame takes over after that long no matter how far the frame got.
+type Animation struct {
+	frames   []*Resource
+	dwell    []time.Duration
+	complete float64
+	timeout  time.Duration
+
+	lock    sync.Mutex
+	current int
+	started time.Time // When the current frame started being watched
+	done    time.Time // When the current frame got complete enough, zero until then
+	canvas  *art.Image
+}
+
+// Creates an animation of the frames, each shown for its dwell time once the share complete of it is drawn
+func NewAnimation(frames []*Resource, dwell []time.Duration, complete float64, timeout time.Duration) *Animation {
+	return &Animation{frames: frames, dwell: dwell, complete: complete, timeout: timeout}
+}
+
+func (a *Animation) Frames() []*Resource {
+	return a.frames
+}
+
+// Returns the frame currently being drawn and its index
+func (a *Animation) Current() (*Resource, int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.frames[a.current], a.current
+}
+
+// Starts tracking broken pixels of the current frame
+func (a *Animation) Watch(canvas *art.Image) {
+	a.lock.Lock()
+	a.canvas = canvas
+	a.started, a.done = time.Now(), time.Time{}
+	r := a.frames[a.current]
+	a.lock.Unlock()
+	r.Watch(canvas)
+}
+
+func (a *Animation) Unwatch() {
+	a.lock.Lock()
+	a.canvas = nil
+	r := a.frames[a.current]
+	a.lock.Unlock()
+	r.Unwatch()
+}
+
+// Moves on to the next frame when it's time, returns whether it did
+func (a *Animation) Tick(now time.Time) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	r := a.frames[a.current]
+	if a.done.IsZero() && r.Completion() >= a.complete {
+		a.done = now
+	}
+	expired := a.timeout > 0 && now.Sub(a.started) >= a.timeout
+	dwelled := !a.done.IsZero() && now.Sub(a.done) >= a.dwell[a.current]
+	if !expired && !dwelled {
+		return false
+	}
+	a.current = (a.current + 1) % len(a.frames)
+	a.started, a.done = now, time.Time{}
+	if a.canvas != nil {
+		r.Unwatch()
+		a.frames[a.current].Watch(a.canvas)
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resource
+
+import (
+	"image"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/xStrom/patriot/art"
+)
+
+// Orders in which GetWork repairs pixels
+const (
+	Scan    = "scan"    // Column by column from the top left corner
+	Random  = "random"  // Any broken pixel
+	Center  = "center"  // Closest to the center first
+	Outline = "outline" // Pixels bordering another color first, then the rest
+	Mask    = "mask"    // Brightest pixel of the mask image first
+	Recent  = "recent"  // Most recently damaged first
+)
+
+func ValidOrder(order string) bool {
+	switch order {
+	case Scan, Random, Center, Outline, Mask, Recent:
+		return true
+	}
+	return false
+}
+
+// Sets the order in which GetWork repairs pixels, maskPath is only used by the Mask order
+func (r *Resource) SetOrder(order, maskPath string) error {
+	coords := r.coords()
+	switch order {
+	case Scan, Random, Recent:
+	case Center:
+		cx, cy := float64(r.x0+r.x1)/2, float64(r.y0+r.y1)/2
+		dist := func(c int) float64 {
+			return math.Hypot(float64(c&0xffff)-cx, float64(c>>16)-cy)
+		}
+		sort.SliceStable(coords, func(i, j int) bool { return dist(coords[i]) < dist(coords[j]) })
+	case Outline:
+		edge := make(map[int]bool, len(coords))
+		for _, c := range coords {
+			edge[c] = r.isEdge(c&0xffff, c>>16)
+		}
+		sort.SliceStable(coords, func(i, j int) bool { return edge[coords[i]] && !edge[coords[j]] })
+	case Mask:
+		weights, err := r.loadMask(maskPath)
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(coords, func(i, j int) bool { return weights[coords[i]] > weights[coords[j]] })
+		r.weights = weights
+	default:
+		return errors.Errorf("Unknown order %q", order)
+	}
+	rank := make(map[int]int, len(coords))
+	for i, c := range coords {
+		rank[c] = i
+	}
+	r.lock.Lock()
+	r.order = order
+	r.rank = rank
+	if order == Random {
+		r.queue = &randomQueue{}
+	} else {
+		r.queue = &rankQueue{recent: order == Recent, rank: rank}
+	}
+	r.queue.reset(r.dirty)
+	r.lock.Unlock()
+	return nil
+}
+
+// Returns the coordinates of all non-transparent pixels of the art in scan order, given up ones included
+func (r *Resource) coords() []int {
+	coords := make([]int, 0, (r.x1-r.x0+1)*(r.y1-r.y0+1))
+	for x := r.x0; x <= r.x1; x++ {
+		for y := r.y0; y <= r.y1; y++ {
+			if r.img.At(x-r.x0, y-r.y0) != art.Transparent {
+				coords = append(coords, x|(y<<16))
+			}
+		}
+	}
+	return coords
+}
+
+// Whether any 4-neighbour of the pixel has another color or is outside the art
+func (r *Resource) isEdge(x, y int) bool {
+	c := r.target(x, y)
+	for _, d := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+		nx, ny := x+d[0], y+d[1]
+		if nx < r.x0 || nx > r.x1 || ny < r.y0 || ny > r.y1 || r.target(nx, ny) != c {
+			return true
+		}
+	}
+	return false
+}
+
+// Loads a grayscale mask the size of the art, returning the weight of every pixel
+func (r *Resource) loadMask(path string) (map[int]int, error) {
+	if path == "" {
+		return nil, errors.New("Mask order needs a mask image")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to open mask")
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to decode mask")
+	}
+	b := img.Bounds()
+	if b.Dx() != r.x1-r.x0+1 || b.Dy() != r.y1-r.y0+1 {
+		return nil, errors.Errorf("Mask is %vx%v but the art is %vx%v", b.Dx(), b.Dy(), r.x1-r.x0+1, r.y1-r.y0+1)
+	}
+	weights := make(map[int]int, b.Dx()*b.Dy())
+	for x := 0; x < b.Dx(); x++ {
+		for y := 0; y < b.Dy(); y++ {
+			// Luminance, already premultiplied by alpha
+			cr, cg, cb, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			lum := (299*cr + 587*cg + 114*cb) / 1000
+			weights[(r.x0+x)|((r.y0+y)<<16)] = int(lum >> 8)
+		}
+	}
+	return weights, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resource
+
+import (
+	"container/heap"
+	"math/rand"
+)
+
+// Broken pixels in the order GetWork repairs them
+type workQueue interface {
+	// Replaces the queue with the broken pixels and the versions that broke them
+	reset(dirty map[int]int)
+	// Adds a pixel that broke, or broke again, at version
+	add(coords, version int)
+	remove(coords int)
+	// Returns the next pixel not in ignorePixels, or -1 if there's none
+	next(ignorePixels map[int]bool) int
+}
+
+// Stale entries get dropped once there are this many times more entries than broken pixels
+const staleFactor = 2
+
+type queued struct {
+	coords  int
+	rank    int
+	version int
+}
+
+// A heap ordered by rank, or by version first with the Recent order.
+// Entries aren't removed when pixels get repaired, only skipped once they surface.
+type rankQueue struct {
+	items  []queued
+	recent bool
+	rank   map[int]int
+	dirty  map[int]int
+}
+
+func (q *rankQueue) Len() int { return len(q.items) }
+
+func (q *rankQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if q.recent && a.version != b.version {
+		return a.version > b.version
+	}
+	return a.rank < b.rank
+}
+
+func (q *rankQueue) Swap(i, j int)      { q.items[i], q.items[j] = q.items[j], q.items[i] }
+func (q *rankQueue) Push(x interface{}) { q.items = append(q.items, x.(queued)) }
+
+func (q *rankQueue) Pop() interface{} {
+	n := len(q.items) - 1
+	item := q.items[n]
+	q.items = q.items[:n]
+	return item
+}
+
+func (q *rankQueue) reset(dirty map[int]int) {
+	q.dirty = dirty
+	q.items = make([]queued, 0, len(dirty))
+	for coords, version := range dirty {
+		q.items = append(q.items, queued{coords, q.rank[coords], version})
+	}
+	heap.Init(q)
+}
+
+func (q *rankQueue) add(coords, version int) {
+	if len(q.items) > staleFactor*len(q.dirty)+64 {
+		q.reset(q.dirty)
+		return
+	}
+	heap.Push(q, queued{coords, q.rank[coords], version})
+}
+
+// Repaired pixels are skipped by next
+func (q *rankQueue) remove(coords int) {}
+
+func (q *rankQueue) next(ignorePixels map[int]bool) int {
+	best := -1
+	var skipped []queued
+	for len(q.items) > 0 {
+		top := q.items[0]
+		if version, ok := q.dirty[top.coords]; !ok || version != top.version {
+			// Repaired, or broken again by a later version that has its own entry
+			heap.Pop(q)
+			continue
+		}
+		if ignorePixels[top.coords] {
+			skipped = append(skipped, heap.Pop(q).(queued))
+			continue
+		}
+		best = top.coords
+		break
+	}
+	for _, item := range skipped {
+		heap.Push(q, item)
+	}
+	return best
+}
+
+// A set that can pick a random pixel, removing by swapping with the last one
+type randomQueue struct {
+	coords []int
+	index  map[int]int
+}
+
+func (q *randomQueue) reset(dirty map[int]int) {
+	q.coords = make([]int, 0, len(dirty))
+	q.index = make(map[int]int, len(dirty))
+	for coords := range dirty {
+		q.index[coords] = len(q.coords)
+		q.coords = append(q.coords, coords)
+	}
+}
+
+func (q *randomQueue) add(coords, version int) {
+	if _, ok := q.index[coords]; ok {
+		return
+	}
+	q.index[coords] = len(q.coords)
+	q.coords = append(q.coords, coords)
+}
+
+func (q *randomQueue) remove(coords int) {
+	i, ok := q.index[coords]
+	if !ok {
+		return
+	}
+	last := q.coords[len(q.coords)-1]
+	q.coords[i] = last
+	q.index[last] = i
+	q.coords = q.coords[:len(q.coords)-1]
+	delete(q.index, coords)
+}
+
+func (q *randomQueue) next(ignorePixels map[int]bool) int {
+	n := len(q.coords)
+	if n == 0 {
+		return -1
+	}
+	// Ignored pixels are few, so random picks almost always hit a pixel to repair
+	for i := 0; i < 8; i++ {
+		if c := q.coords[rand.Intn(n)]; !ignorePixels[c] {
+			return c
+		}
+	}
+	start := rand.Intn(n)
+	for i := 0; i < n; i++ {
+		if c := q.coords[(start+i)%n]; !ignorePixels[c] {
+			return c
+		}
+	}
+	return -1
+}
@@ -15,7 +15,9 @@
 package resource
 
 import (
+	"image"
 	"io/ioutil"
+	"sync"
 
 	"github.com/pkg/errors"
 
@@ -23,14 +25,33 @@ import (
 )
 
 type Resource struct {
-	img *art.Image
-	x0  int
-	x1  int
-	y0  int
-	y1  int
+	img     *art.Image
+	x0      int
+	x1      int
+	y0      int
+	y1      int
+	order   string
+	rank    map[int]int    // Repair order of every non-transparent pixel, lower goes first
+	weights map[int]int    // Mask weights, only with the Mask order
+	exclude map[int]bool   // Pixels given up to other art, guarded by lock once watching
+	accept  map[int]uint32 // Bitmask of the colors good enough for each pixel, see SetAccept
+
+	lock   sync.Mutex
+	canvas *art.Image
+	dirty  map[int]int // Broken pixels of the watched canvas and the version that broke them
+	queue  workQueue   // The dirty pixels in repair order
 }
 
 func New(x, y int, filepath string) (*Resource, error) {
+	img, err := LoadImage(filepath)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromImage(x, y, img), nil
+}
+
+// Loads a png that uses the exact canvas colors
+func LoadImage(filepath string) (*art.Image, error) {
 	data, err := ioutil.ReadFile(filepath)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to read file")
@@ -40,6 +61,11 @@ func New(x, y int, filepath string) (*Resource, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to parse image")
 	}
+	return img, nil
+}
+
+// Creates a resource of the art in img with its top left corner at x, y
+func NewFromImage(x, y int, img *art.Image) *Resource {
 	w, h := img.Dimensions()
 	r := &Resource{
 		img: img,
@@ -48,33 +74,159 @@ func New(x, y int, filepath string) (*Resource, error) {
 		y0:  y,
 		y1:  y + h - 1,
 	}
-	return r, nil
-}
-
-// Fixes any broken pixels in the provided image
-func (r *Resource) GetWork(image *art.Image, ignorePixels map[int]bool) *art.Pixel {
-	for x := r.x0; x <= r.x1; x++ {
-		for y := r.y0; y <= r.y1; y++ {
-			if ignorePixels[x|(y<<16)] {
-				continue
-			}
-			c1 := r.img.At(x-r.x0, y-r.y0)
-			if c1 == art.Transparent {
-				continue
-			}
-			c2 := image.At(x, y)
-			if c1 != c2 {
-				return &art.Pixel{x, y, c1}
-			}
+	r.SetOrder(Scan, "")
+	return r
+}
+
+// Starts tracking broken pixels of the canvas, replacing any previously watched canvas
+func (r *Resource) Watch(canvas *art.Image) {
+	r.Unwatch()
+	r.lock.Lock()
+	r.canvas = canvas
+	r.lock.Unlock()
+	canvas.AddListener(r)
+	r.KeyframeChanged(canvas)
+}
+
+func (r *Resource) Unwatch() {
+	r.lock.Lock()
+	canvas := r.canvas
+	r.canvas = nil
+	r.dirty = nil
+	r.queue.reset(nil)
+	r.lock.Unlock()
+	if canvas != nil {
+		canvas.RemoveListener(r)
+	}
+}
+
+// Rescans the whole art against the new keyframe
+func (r *Resource) KeyframeChanged(canvas *art.Image) {
+	// Hold the lock during the scan so that concurrent pixel changes get applied after it
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.canvas != canvas {
+		return
+	}
+	r.rescan()
+}
+
+// Must be called with the lock held while watching a canvas
+func (r *Resource) rescan() {
+	dirty := map[int]int{}
+	for coords := range r.rank {
+		x, y := coords&0xffff, coords>>16
+		if !r.exclude[coords] && !r.satisfied(x, y, r.canvas.At(x, y)) {
+			dirty[coords] = 0
 		}
 	}
-	return nil
+	r.dirty = dirty
+	r.queue.reset(dirty)
 }
 
-// TODO: Bounds check function, so that not every art needs to be looped through after every pixel update --- make a dirty region system
-func (r *Resource) CheckPixel(x, y, c int) {
+func (r *Resource) PixelChanged(x, y, c, version int) {
 	// Make sure the pixel is even in bounds
-	if x >= r.x0 && x <= r.x1 && y >= r.y0 && y <= r.y1 {
+	if x < r.x0 || x > r.x1 || y < r.y0 || y > r.y1 {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.dirty == nil || r.target(x, y) == art.Transparent {
+		return
+	}
+	coords := x | (y << 16)
+	if !r.satisfied(x, y, c) {
+		r.dirty[coords] = version
+		r.queue.add(coords, version)
+	} else {
+		delete(r.dirty, coords)
+		r.queue.remove(coords)
+	}
+}
+
+// Returns the next broken pixel in the watched canvas to fix
+func (r *Resource) GetWork(ignorePixels map[int]bool) *art.Pixel {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.dirty == nil {
+		return nil
+	}
+	best := r.queue.next(ignorePixels)
+	if best < 0 {
+		return nil
+	}
+	x, y := best&0xffff, best>>16
+	return &art.Pixel{X: x, Y: y, C: r.target(x, y)}
+}
+
+// Returns how important the pixel at the canvas coordinates is, 1 unless the Mask order weighs it
+func (r *Resource) Importance(x, y int) int {
+	if r.weights == nil {
+		return 1
+	}
+	return r.weights[x|(y<<16)] + 1
+}
+
+// Returns the number of broken pixels in the watched canvas
+func (r *Resource) Damage(ignorePixels map[int]bool) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	n := len(r.dirty)
+	for coords := range ignorePixels {
+		if _, ok := r.dirty[coords]; ok {
+			n--
+		}
+	}
+	return n
+}
+
+// Returns the canvas area the art covers
+func (r *Resource) Bounds() image.Rectangle {
+	return image.Rect(r.x0, r.y0, r.x1+1, r.y1+1)
+}
+
+// Returns the color the art wants at the canvas coordinates, Transparent if it doesn't care
+func (r *Resource) Target(x, y int) int {
+	if x < r.x0 || x > r.x1 || y < r.y0 || y > r.y1 {
+		return art.Transparent
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.target(x, y)
+}
+
+// Gives up the pixels at the canvas coordinates so that they're left to other art, taking back the ones given up before.
+// The watched canvas gets rescanned.
+func (r *Resource) SetExcluded(coords []int) {
+	exclude := make(map[int]bool, len(coords))
+	for _, c := range coords {
+		if _, ok := r.rank[c]; ok {
+			exclude[c] = true
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.exclude = exclude
+	if r.canvas != nil {
+		r.rescan()
+	}
+}
+
+// Returns the color the art wants at the canvas coordinates
+func (r *Resource) target(x, y int) int {
+	if r.exclude[x|(y<<16)] {
+		return art.Transparent
+	}
+	return r.img.At(x-r.x0, y-r.y0)
+}
 
+// Returns the share of the art that's intact in the watched canvas, from 0 to 1
+func (r *Resource) Completion() float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	n := len(r.rank) - len(r.exclude)
+	if n == 0 {
+		return 1
 	}
+	return 1 - float64(len(r.dirty))/float64(n)
 }
@@ -0,0 +1,118 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resource
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/xStrom/patriot/art"
+)
+
+// Returns a resource of Black art at 10, 10 watching a White canvas
+func testResource(w, h int, order string) (*Resource, *art.Image) {
+	colors := make([]int, w*h)
+	for j := range colors {
+		colors[j] = art.Black
+	}
+	r := NewFromImage(10, 10, art.NewImage(1, w, h, colors))
+	if err := r.SetOrder(order, ""); err != nil {
+		panic(err)
+	}
+	canvas := art.NewImage(1, art.CanvasWidth, art.CanvasHeight, make([]int, art.CanvasWidth*art.CanvasHeight))
+	r.Watch(canvas)
+	return r, canvas
+}
+
+// Picks the next pixel the slow way, by going through all of them
+func expectedWork(r *Resource, ignorePixels map[int]bool) int {
+	best, bestRank, bestVersion := -1, 0, 0
+	for coords, version := range r.dirty {
+		if ignorePixels[coords] {
+			continue
+		}
+		rank := r.rank[coords]
+		if best >= 0 {
+			if r.order == Recent && (version < bestVersion || version == bestVersion && rank > bestRank) {
+				continue
+			}
+			if r.order != Recent && rank > bestRank {
+				continue
+			}
+		}
+		best, bestRank, bestVersion = coords, rank, version
+	}
+	return best
+}
+
+func TestGetWork(t *testing.T) {
+	for _, order := range []string{Scan, Center, Outline, Recent, Random} {
+		r, canvas := testResource(20, 15, order)
+		rnd := rand.New(rand.NewSource(1))
+		for version := 2; version < 5000; version++ {
+			// Break and repair pixels, some of them several times over
+			x, y := 10+rnd.Intn(20), 10+rnd.Intn(15)
+			c := art.Black
+			if rnd.Intn(3) > 0 {
+				c = rnd.Intn(art.DarkPurple + 1)
+			}
+			canvas.UpdatePixel(x, y, c, version)
+
+			ignore := map[int]bool{}
+			for i := rnd.Intn(4); i > 0; i-- {
+				ignore[(10+rnd.Intn(20))|((10+rnd.Intn(15))<<16)] = true
+			}
+			want := expectedWork(r, ignore)
+			p := r.GetWork(ignore)
+			if want < 0 {
+				if p != nil {
+					t.Fatalf("%v: got %v:%v with nothing to repair", order, p.X, p.Y)
+				}
+				continue
+			}
+			if p == nil {
+				t.Fatalf("%v: got nothing with %v broken pixels", order, len(r.dirty))
+			}
+			got := p.X | (p.Y << 16)
+			if _, ok := r.dirty[got]; !ok || ignore[got] {
+				t.Fatalf("%v: got %v:%v which doesn't need work", order, p.X, p.Y)
+			}
+			if order != Random && got != want {
+				t.Fatalf("%v: got %v:%v, want %v:%v", order, p.X, p.Y, want&0xffff, want>>16)
+			}
+		}
+	}
+}
+
+func BenchmarkGetWork(b *testing.B) {
+	for _, order := range []string{Scan, Recent, Random} {
+		b.Run(order, func(b *testing.B) {
+			// A 100x100 resource with every pixel broken
+			r, _ := testResource(100, 100, order)
+			ignore := map[int]bool{}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p := r.GetWork(ignore)
+				ignore[p.X|(p.Y<<16)] = true
+				if len(ignore) == planPoolSize {
+					ignore = map[int]bool{}
+				}
+			}
+		})
+	}
+}
+
+// Same as the painter's, how many pixels it asks for per plan
+const planPoolSize = 30
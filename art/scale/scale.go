@@ -0,0 +1,134 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scale resizes images before they get quantized into art
+package scale
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Resizing filters
+const (
+	Nearest  = "nearest"
+	Bilinear = "bilinear"
+	Box      = "box" // Averages every source pixel covered, best for shrinking
+)
+
+// Resizes img to w x h, a zero w or h keeps the aspect ratio
+func Resize(img image.Image, w, h int, filter string) (*image.NRGBA, error) {
+	b := img.Bounds()
+	if w == 0 && h == 0 {
+		w, h = b.Dx(), b.Dy()
+	} else if w == 0 {
+		w = int(math.Round(float64(b.Dx()) * float64(h) / float64(b.Dy())))
+	} else if h == 0 {
+		h = int(math.Round(float64(b.Dy()) * float64(w) / float64(b.Dx())))
+	}
+	if w <= 0 || h <= 0 {
+		return nil, errors.Errorf("Invalid size %vx%v", w, h)
+	}
+	var sample func(x, y int) color.Color
+	sx, sy := float64(b.Dx())/float64(w), float64(b.Dy())/float64(h)
+	switch filter {
+	case Nearest:
+		sample = func(x, y int) color.Color {
+			return img.At(b.Min.X+int((float64(x)+0.5)*sx), b.Min.Y+int((float64(y)+0.5)*sy))
+		}
+	case Bilinear:
+		sample = func(x, y int) color.Color {
+			return bilinear(img, (float64(x)+0.5)*sx-0.5, (float64(y)+0.5)*sy-0.5)
+		}
+	case Box:
+		sample = func(x, y int) color.Color {
+			return box(img, float64(x)*sx, float64(y)*sy, float64(x+1)*sx, float64(y+1)*sy)
+		}
+	default:
+		return nil, errors.Errorf("Unknown filter %q", filter)
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			out.Set(x, y, sample(x, y))
+		}
+	}
+	return out, nil
+}
+
+// Premultiplied color accumulator
+type acc struct {
+	r, g, b, a, weight float64
+}
+
+func (a *acc) add(c color.Color, weight float64) {
+	r, g, b, al := c.RGBA()
+	a.r += float64(r) * weight
+	a.g += float64(g) * weight
+	a.b += float64(b) * weight
+	a.a += float64(al) * weight
+	a.weight += weight
+}
+
+func (a *acc) color() color.Color {
+	if a.weight == 0 {
+		return color.RGBA64{}
+	}
+	return color.RGBA64{
+		uint16(a.r/a.weight + 0.5),
+		uint16(a.g/a.weight + 0.5),
+		uint16(a.b/a.weight + 0.5),
+		uint16(a.a/a.weight + 0.5),
+	}
+}
+
+func bilinear(img image.Image, fx, fy float64) color.Color {
+	b := img.Bounds()
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+	clampX := func(x int) int { return b.Min.X + clamp(x, b.Dx()-1) }
+	clampY := func(y int) int { return b.Min.Y + clamp(y, b.Dy()-1) }
+	a := &acc{}
+	a.add(img.At(clampX(x0), clampY(y0)), (1-tx)*(1-ty))
+	a.add(img.At(clampX(x0+1), clampY(y0)), tx*(1-ty))
+	a.add(img.At(clampX(x0), clampY(y0+1)), (1-tx)*ty)
+	a.add(img.At(clampX(x0+1), clampY(y0+1)), tx*ty)
+	return a.color()
+}
+
+func box(img image.Image, x0, y0, x1, y1 float64) color.Color {
+	b := img.Bounds()
+	a := &acc{}
+	for y := int(math.Floor(y0)); y < int(math.Ceil(y1)); y++ {
+		wy := math.Min(y1, float64(y+1)) - math.Max(y0, float64(y))
+		for x := int(math.Floor(x0)); x < int(math.Ceil(x1)); x++ {
+			wx := math.Min(x1, float64(x+1)) - math.Max(x0, float64(x))
+			a.add(img.At(b.Min.X+x, b.Min.Y+y), wx*wy)
+		}
+	}
+	return a.color()
+}
+
+func clamp(v, max int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/config"
+	"github.com/xStrom/patriot/log"
+	"github.com/xStrom/patriot/painter"
+)
+
+func checkCommand(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	resources := fs.String("resources", "resources.json", "Config file to check")
+	preview := fs.String("preview", "", "Where to write a png of the merged art")
+	all := fs.Bool("all", false, "Check disabled resources too")
+	palette := fs.String("palette", "", "Palette of the canvas, see patriot -palette")
+	fs.Parse(args)
+	usePalette(*palette)
+
+	cfg, err := config.Load(*resources)
+	if err != nil {
+		log.Infof("Failed to load resources: %v", err)
+		os.Exit(1)
+	}
+	if *all {
+		for _, rc := range cfg.Resources {
+			rc.Enabled = nil
+		}
+	}
+	img, err := painter.Composite(cfg)
+	if img != nil && *preview != "" {
+		if err := writePNG(*preview, img.Paletted(art.CurrentPalette())); err != nil {
+			log.Infof("Failed to write preview: %v", err)
+			os.Exit(1)
+		}
+		log.Infof("Wrote the merged art to %v", *preview)
+	}
+	if err != nil {
+		log.Infof("Resources don't check out: %v", err)
+		os.Exit(1)
+	}
+	log.Infof("All %v resources check out", len(cfg.Enabled()))
+}
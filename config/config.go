@@ -0,0 +1,461 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config loads the list of art the bot defends
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/art/font"
+	"github.com/xStrom/patriot/art/quantize"
+	"github.com/xStrom/patriot/art/resource"
+)
+
+// How the painter splits its budget between resources
+const (
+	Strict   = "strict"   // Highest priority resource with work always goes first
+	Weighted = "weighted" // Weighted round-robin within the highest priority that has work
+	Damage   = "damage"   // Randomly by weight times broken pixels within the highest priority that has work
+)
+
+type Config struct {
+	Scheduling string      `json:"scheduling"` // Defaults to strict
+	CostAware  bool        `json:"costAware"`  // Plan each window to repair the most per point
+	Resources  []*Resource `json:"resources"`
+
+	path string
+}
+
+type Resource struct {
+	Name       string              `json:"name"`       // Defaults to the file name without the extension
+	Path       string              `json:"path"`       // A png, or a gif whose frames get animated
+	Frames     []*Frame            `json:"frames"`     // Animation frames instead of a path
+	Complete   float64             `json:"complete"`   // Share of a frame to draw before its dwell starts, defaults to 0.95
+	Timeout    Duration            `json:"timeout"`    // Moves on to the next frame after this long no matter what, by default never
+	Text       string              `json:"text"`       // Text to render instead of a path, lines separated by \n
+	Color      string              `json:"color"`      // Color of the text, defaults to Black
+	Background string              `json:"background"` // Color behind the text, defaults to transparent
+	X          int                 `json:"x"`
+	Y          int                 `json:"y"`
+	Priority   int                 `json:"priority"`   // Higher goes first
+	Weight     int                 `json:"weight"`     // Share of the budget among equal priority, defaults to 1
+	Enabled    *bool               `json:"enabled"`    // Defaults to true
+	Order      string              `json:"order"`      // Repair order, defaults to scan
+	Mask       string              `json:"mask"`       // Weight image for the mask order
+	Quantize   string              `json:"quantize"`   // How to map off-palette colors, by default they're kept unknown
+	Accept     map[string][]string `json:"accept"`     // Colors that may stand in for a target color, by name
+	Alternates string              `json:"alternates"` // Png of the art's size with another acceptable color per pixel
+	Beats      []string            `json:"beats"`      // Names of resources this one wins overlapping pixels against
+	Start      *time.Time          `json:"start"`      // Defended from this RFC3339 time on
+	End        *time.Time          `json:"end"`        // Defended until this RFC3339 time
+	Cron       string              `json:"cron"`       // Defended for the duration after every time this 5 field cron expression matches, in local time
+	Duration   Duration            `json:"duration"`   // How long every cron activation lasts
+	Notes      string              `json:"notes"`
+
+	index int
+	cron  *cronSchedule
+}
+
+type Frame struct {
+	Path  string   `json:"path"`
+	Dwell Duration `json:"dwell"` // How long the frame is shown once complete
+}
+
+// Duration is written as a string like "1m30s" in the config
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.Errorf("duration should be a string like \"1m30s\", got %s", data)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// Loads and validates the config file at path
+func Load(path string) (*Config, error) {
+	c, err := Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "Invalid config %v", path)
+	}
+	return c, nil
+}
+
+// Parses the config and fills in defaults without validating it, e.g. to find the files a broken config references
+func Parse(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read config")
+	}
+	c := &Config{path: path}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		if se, ok := err.(*json.SyntaxError); ok {
+			line, col := position(data, se.Offset)
+			return nil, errors.Errorf("%v:%v:%v: %v", path, line, col, se)
+		}
+		return nil, errors.Wrapf(err, "Failed to parse %v", path)
+	}
+	if c.Scheduling == "" {
+		c.Scheduling = Strict
+	}
+	for i, r := range c.Resources {
+		if r == nil {
+			return nil, errors.Errorf("%v: resources[%v] is null", path, i)
+		}
+		r.index = i
+		if r.Name == "" {
+			path := r.Path
+			if path == "" && len(r.Frames) > 0 && r.Frames[0] != nil {
+				path = r.Frames[0].Path
+			}
+			r.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			if r.Text != "" {
+				r.Name = r.Text
+			}
+		}
+		if r.Complete == 0 {
+			r.Complete = 0.95
+		}
+		if r.Color == "" {
+			r.Color = "Black"
+		}
+		if r.Background == "" {
+			r.Background = "Transparent"
+		}
+		if r.Weight == 0 {
+			r.Weight = 1
+		}
+		if r.Order == "" {
+			r.Order = resource.Scan
+		}
+	}
+	return c, nil
+}
+
+// Path of the file the config was loaded from
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Returns the resources that are enabled
+func (c *Config) Enabled() []*Resource {
+	rs := make([]*Resource, 0, len(c.Resources))
+	for _, r := range c.Resources {
+		if r.IsEnabled() {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+func (c *Config) Validate() error {
+	switch c.Scheduling {
+	case Strict, Weighted, Damage:
+	default:
+		return errors.Errorf("unknown scheduling %q", c.Scheduling)
+	}
+	names := map[string]*Resource{}
+	for _, r := range c.Resources {
+		if err := r.validate(); err != nil {
+			return errors.Wrap(err, r.String())
+		}
+		if other, ok := names[r.Name]; ok {
+			return errors.Errorf("%v has the same name %q as %v", r, r.Name, other)
+		}
+		names[r.Name] = r
+	}
+	for _, r := range c.Resources {
+		for _, name := range r.Beats {
+			other, ok := names[name]
+			switch {
+			case !ok:
+				return errors.Errorf("%v beats unknown resource %q", r, name)
+			case other == r:
+				return errors.Errorf("%v beats itself", r)
+			case other.beats(r.Name):
+				return errors.Errorf("%v and %v beat each other", r, other)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Resource) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+// Whether the resource has a start, end or cron limiting when it's defended
+func (r *Resource) Scheduled() bool {
+	return r.Start != nil || r.End != nil || r.Cron != ""
+}
+
+// Whether the resource should be defended at the time
+func (r *Resource) Active(now time.Time) bool {
+	if r.Start != nil && now.Before(*r.Start) {
+		return false
+	}
+	if r.End != nil && !now.Before(*r.End) {
+		return false
+	}
+	if r.cron != nil {
+		return r.cron.matchedWithin(now, r.Duration.Duration)
+	}
+	return true
+}
+
+// How far ahead Concurrent looks for cron activations
+const concurrentHorizon = 366 * 24 * time.Hour
+
+// Whether both resources can be active at the same time from now on, cron activations are looked for a year ahead
+func (r *Resource) Concurrent(other *Resource, now time.Time) bool {
+	both := func(t time.Time) bool {
+		return r.Active(t) && other.Active(t)
+	}
+	if both(now) {
+		return true
+	}
+	// Any time both are active, the one that got activated last did so at its start or a cron match
+	for _, rc := range []*Resource{r, other} {
+		if rc.Start != nil && rc.Start.After(now) && both(*rc.Start) {
+			return true
+		}
+		if rc.cron != nil && rc.cron.each(now, now.Add(concurrentHorizon), both) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the text and background colors
+func (r *Resource) TextColors() (int, int, error) {
+	fg, ok := art.ColorByName(r.Color)
+	if !ok || fg == art.Transparent {
+		return 0, 0, errors.Errorf("unknown text color %q", r.Color)
+	}
+	bg, ok := art.ColorByName(r.Background)
+	if !ok {
+		return 0, 0, errors.Errorf("unknown background color %q", r.Background)
+	}
+	return fg, bg, nil
+}
+
+// Returns the accept rules as palette colors
+func (r *Resource) AcceptColors() (map[int][]int, error) {
+	rules := make(map[int][]int, len(r.Accept))
+	for target, names := range r.Accept {
+		t, ok := art.ColorByName(target)
+		if !ok || t == art.Transparent {
+			return nil, errors.Errorf("unknown accept color %q", target)
+		}
+		for _, name := range names {
+			c, ok := art.ColorByName(name)
+			if !ok || c == art.Transparent {
+				return nil, errors.Errorf("unknown accept color %q for %v", name, target)
+			}
+			rules[t] = append(rules[t], c)
+		}
+	}
+	return rules, nil
+}
+
+// Whether the resource wins overlapping pixels against the named one
+func (r *Resource) beats(name string) bool {
+	for _, n := range r.Beats {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns which of the two resources wins overlapping pixels, or nil if neither was declared to
+func (r *Resource) Winner(other *Resource) *Resource {
+	switch {
+	case r.beats(other.Name):
+		return r
+	case other.beats(r.Name):
+		return other
+	}
+	return nil
+}
+
+// Identifies the entry for logs and errors, e.g. resources[2] (data/dota.png)
+func (r *Resource) String() string {
+	if r.Path == "" {
+		return fmt.Sprintf("resources[%v] (%v)", r.index, r.Name)
+	}
+	return fmt.Sprintf("resources[%v] (%v)", r.index, r.Path)
+}
+
+// Returns the paths of the art, one per frame when the frames are listed and none for text
+func (r *Resource) Paths() []string {
+	if r.Path != "" {
+		return []string{r.Path}
+	}
+	paths := make([]string, len(r.Frames))
+	for i, f := range r.Frames {
+		paths[i] = f.Path
+	}
+	return paths
+}
+
+func (r *Resource) validate() error {
+	sources := 0
+	for _, set := range []bool{r.Path != "", len(r.Frames) > 0, r.Text != ""} {
+		if set {
+			sources++
+		}
+	}
+	switch {
+	case sources == 0:
+		return errors.New("path is missing")
+	case sources > 1:
+		return errors.New("only one of path, frames or text can be set")
+	}
+	for i, f := range r.Frames {
+		if f == nil || f.Path == "" {
+			return errors.Errorf("frames[%v] has no path", i)
+		}
+	}
+	if r.Start != nil && r.End != nil && !r.Start.Before(*r.End) {
+		return errors.Errorf("start %v isn't before end %v", r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339))
+	}
+	if r.Cron != "" {
+		cron, err := parseCron(r.Cron)
+		if err != nil {
+			return err
+		}
+		if r.Duration.Duration <= 0 {
+			return errors.New("cron needs a duration")
+		}
+		r.cron = cron
+	} else if r.Duration.Duration != 0 {
+		return errors.New("duration needs a cron")
+	}
+	if r.Complete < 0 || r.Complete > 1 {
+		return errors.Errorf("complete %v isn't between 0 and 1", r.Complete)
+	}
+	if r.Weight < 0 {
+		return errors.Errorf("weight %v is negative", r.Weight)
+	}
+	if !resource.ValidOrder(r.Order) {
+		return errors.Errorf("unknown order %q", r.Order)
+	}
+	if r.Quantize != "" && !quantize.ValidMode(r.Quantize) {
+		return errors.Errorf("unknown quantize mode %q", r.Quantize)
+	}
+	if r.Order == resource.Mask && r.Mask == "" {
+		return errors.New("mask order needs a mask")
+	}
+	if _, err := r.AcceptColors(); err != nil {
+		return err
+	}
+	if r.X < 0 || r.X >= art.CanvasWidth || r.Y < 0 || r.Y >= art.CanvasHeight {
+		return errors.Errorf("position %v,%v is outside the canvas", r.X, r.Y)
+	}
+	if r.Text != "" {
+		if _, _, err := r.TextColors(); err != nil {
+			return err
+		}
+		w, h := font.Size(r.Text)
+		if r.X+w > art.CanvasWidth || r.Y+h > art.CanvasHeight {
+			return errors.Errorf("%vx%v text at %v,%v doesn't fit on the canvas", w, h, r.X, r.Y)
+		}
+		return nil
+	}
+	var first image.Config
+	for i, path := range r.Paths() {
+		ic, err := decodeConfig(path)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			first = ic
+		} else if ic.Width != first.Width || ic.Height != first.Height {
+			return errors.Errorf("%v is %vx%v but the first frame is %vx%v", path, ic.Width, ic.Height, first.Width, first.Height)
+		}
+	}
+	if r.X+first.Width > art.CanvasWidth || r.Y+first.Height > art.CanvasHeight {
+		return errors.Errorf("%vx%v image at %v,%v doesn't fit on the canvas", first.Width, first.Height, r.X, r.Y)
+	}
+	return nil
+}
+
+// Returns the canvas area the art covers
+func (r *Resource) Bounds() (image.Rectangle, error) {
+	if r.Text != "" {
+		w, h := font.Size(r.Text)
+		return image.Rect(r.X, r.Y, r.X+w, r.Y+h), nil
+	}
+	paths := r.Paths()
+	if len(paths) == 0 {
+		return image.Rectangle{}, errors.New("path is missing")
+	}
+	ic, err := decodeConfig(paths[0])
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	return image.Rect(r.X, r.Y, r.X+ic.Width, r.Y+ic.Height), nil
+}
+
+func decodeConfig(path string) (image.Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer f.Close()
+	ic, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return image.Config{}, errors.Wrapf(err, "Failed to decode %v", path)
+	}
+	return ic, nil
+}
+
+// Returns the 1-based line and column of offset in data
+func position(data []byte, offset int64) (int, int) {
+	line, col := 1, 1
+	for _, b := range data[:offset] {
+		if b == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
@@ -0,0 +1,213 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A standard 5 field cron expression: minute, hour, day of month, month and day of week
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // Bit i is set when value i matches
+	domStar, dowStar              bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, errors.Errorf("cron %q should have 5 fields", spec)
+	}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cron %q %v", spec, cronFields[i].name)
+		}
+		sets[i] = set
+	}
+	// Sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domStar: fields[2] == "*", dowStar: fields[4] == "*",
+	}, nil
+}
+
+// Parses comma separated values, ranges and steps like 1,5-10,*/15
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+			if lo < min || hi > max || lo > hi {
+				return 0, errors.Errorf("%q is outside %v-%v", part, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// Whether the minute of t matches
+func (c *cronSchedule) matches(t time.Time) bool {
+	return c.minute&(1<<uint(t.Minute())) != 0 && c.hour&(1<<uint(t.Hour())) != 0 && c.dayMatches(t)
+}
+
+// Whether the day of t matches, regardless of the time of day
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	if c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	// Like cron, when both days are restricted either one matching is enough
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Returns the highest value of the set that's at most limit, -1 if there's none
+func highest(set uint64, limit int) int {
+	if limit < 0 {
+		return -1
+	}
+	if limit < 63 {
+		set &= 1<<uint(limit+1) - 1
+	}
+	return bits.Len64(set) - 1
+}
+
+// Returns the lowest value of the set that's at least limit, -1 if there's none
+func lowest(set uint64, limit int) int {
+	if limit > 63 {
+		return -1
+	}
+	set &^= 1<<uint(limit) - 1
+	if set == 0 {
+		return -1
+	}
+	return bits.TrailingZeros64(set)
+}
+
+// Returns the latest matching minute at or before t, if it's after since
+func (c *cronSchedule) latest(t, since time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	y, m, d := t.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	hour, minute := t.Hour(), t.Minute()
+	for {
+		if c.dayMatches(day) {
+			h := highest(c.hour, hour)
+			mi := -1
+			if h == hour {
+				mi = highest(c.minute, minute)
+				if mi < 0 {
+					h = highest(c.hour, hour-1)
+				}
+			}
+			if h >= 0 && mi < 0 {
+				mi = highest(c.minute, 59)
+			}
+			if h >= 0 && mi >= 0 {
+				match := time.Date(day.Year(), day.Month(), day.Day(), h, mi, 0, 0, day.Location())
+				return match, match.After(since)
+			}
+		}
+		if !day.After(since) {
+			return time.Time{}, false
+		}
+		y, m, d = day.AddDate(0, 0, -1).Date()
+		day = time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+		hour, minute = 23, 59
+	}
+}
+
+// Whether any minute within the duration up to t matches
+func (c *cronSchedule) matchedWithin(t time.Time, d time.Duration) bool {
+	t = t.Truncate(time.Minute)
+	_, ok := c.latest(t, t.Add(-d))
+	return ok
+}
+
+// Calls f with every matching minute from from up to to, until f returns true
+func (c *cronSchedule) each(from, to time.Time, f func(time.Time) bool) bool {
+	from = from.Truncate(time.Minute)
+	y, m, d := from.Date()
+	hour, minute := from.Hour(), from.Minute()
+	for day := time.Date(y, m, d, 0, 0, 0, 0, from.Location()); day.Before(to); {
+		if c.dayMatches(day) {
+			for h := lowest(c.hour, hour); h >= 0; h = lowest(c.hour, h+1) {
+				if h > hour {
+					minute = 0
+				}
+				for mi := lowest(c.minute, minute); mi >= 0; mi = lowest(c.minute, mi+1) {
+					t := time.Date(day.Year(), day.Month(), day.Day(), h, mi, 0, 0, day.Location())
+					if !t.Before(to) {
+						return false
+					}
+					if f(t) {
+						return true
+					}
+				}
+			}
+		}
+		y, m, d = day.AddDate(0, 0, 1).Date()
+		day = time.Date(y, m, d, 0, 0, 0, 0, from.Location())
+		hour, minute = 0, 0
+	}
+	return false
+}
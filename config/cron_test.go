@@ -0,0 +1,84 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+var testCrons = []string{"0 20 * * 5", "*/15 * * * *", "30 2 29 2 *", "0 0 1,15 * 1", "5-10 3,4 * 1-6 *", "* * * * *", "0 0 31 * *"}
+
+// Whether any minute within the duration up to t matches, found by trying every one of them
+func matchedWithinSlowly(c *cronSchedule, t time.Time, d time.Duration) bool {
+	t = t.Truncate(time.Minute)
+	for back := time.Duration(0); back < d; back += time.Minute {
+		if c.matches(t.Add(-back)) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMatchedWithin(t *testing.T) {
+	durations := []time.Duration{time.Minute, 90 * time.Second, 30 * time.Minute, 3 * time.Hour, 48 * time.Hour}
+	rnd := rand.New(rand.NewSource(1))
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, spec := range testCrons {
+		c, err := parseCron(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			now := base.Add(time.Duration(rnd.Int63n(int64(3 * 365 * 24 * time.Hour))))
+			d := durations[rnd.Intn(len(durations))]
+			if want, got := matchedWithinSlowly(c, now, d), c.matchedWithin(now, d); got != want {
+				t.Fatalf("%q at %v within %v: got %v, want %v", spec, now, d, got, want)
+			}
+		}
+	}
+}
+
+func TestEach(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, spec := range testCrons {
+		c, err := parseCron(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		from := base.Add(time.Duration(rnd.Int63n(int64(365 * 24 * time.Hour))))
+		to := from.Add(3 * 24 * time.Hour)
+		var got, want []time.Time
+		c.each(from, to, func(t time.Time) bool {
+			got = append(got, t)
+			return false
+		})
+		for m := from.Truncate(time.Minute); m.Before(to); m = m.Add(time.Minute) {
+			if c.matches(m) {
+				want = append(want, m)
+			}
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%q: got %v matches, want %v", spec, len(got), len(want))
+		}
+		for i := range got {
+			if !got[i].Equal(want[i]) {
+				t.Fatalf("%q: got match %v, want %v", spec, got[i], want[i])
+			}
+		}
+	}
+}
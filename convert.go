@@ -0,0 +1,154 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/art/quantize"
+	"github.com/xStrom/patriot/art/scale"
+	"github.com/xStrom/patriot/log"
+	"github.com/xStrom/patriot/painter"
+)
+
+const previewScale = 8
+
+func convertCommand(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	in := fs.String("in", "", "Image to convert, png, gif or jpeg")
+	out := fs.String("out", "", "Where to write the art, defaults to data/<name>.png")
+	width := fs.Int("width", 0, "Width of the art, 0 keeps the aspect ratio")
+	height := fs.Int("height", 0, "Height of the art, 0 keeps the aspect ratio")
+	filter := fs.String("filter", scale.Box, "Scaling filter: nearest, bilinear or box")
+	dither := fs.String("dither", "none", "Dithering: none, floyd-steinberg or ordered")
+	palette := fs.String("palette", "", "Palette of the canvas, see patriot -palette")
+	force := fs.Bool("force", false, "Allow the art or its preview to overwrite the input")
+	fs.Parse(args)
+	usePalette(*palette)
+
+	if *in == "" {
+		log.Infof("Missing -in")
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *out == "" {
+		base := filepath.Base(*in)
+		*out = filepath.Join("data", strings.TrimSuffix(base, filepath.Ext(base))+".png")
+	}
+	if !*force {
+		for _, path := range []string{*out, previewPath(*out)} {
+			if sameFile(*in, path) {
+				log.Infof("Converting %v would overwrite it with %v, pick another -out or use -force", *in, path)
+				os.Exit(2)
+			}
+		}
+	}
+	mode := *dither
+	if mode == "none" {
+		mode = quantize.Nearest
+	}
+	if mode != quantize.Nearest && mode != quantize.FloydSteinberg && mode != quantize.Ordered {
+		log.Infof("Unknown dithering %q", *dither)
+		os.Exit(2)
+	}
+
+	if err := convert(*in, *out, *width, *height, *filter, mode); err != nil {
+		log.Infof("Failed to convert: %v", err)
+		os.Exit(1)
+	}
+}
+
+func previewPath(out string) string {
+	return strings.TrimSuffix(out, filepath.Ext(out)) + ".preview.png"
+}
+
+// Whether both paths lead to the same file, or would once b gets created
+func sameFile(a, b string) bool {
+	fa, errA := os.Stat(a)
+	fb, errB := os.Stat(b)
+	if errA == nil && errB == nil {
+		return os.SameFile(fa, fb)
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+func convert(in, out string, width, height int, filter, mode string) error {
+	f, err := os.Open(in)
+	if err != nil {
+		return errors.Wrap(err, "Failed to open image")
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return errors.Wrap(err, "Failed to decode image")
+	}
+	scaled, err := scale.Resize(src, width, height, filter)
+	if err != nil {
+		return err
+	}
+	p := art.CurrentPalette()
+	img, err := quantize.Quantize(scaled, p, mode)
+	if err != nil {
+		return err
+	}
+
+	paletted := img.Paletted(p)
+	if err := writePNG(out, paletted); err != nil {
+		return err
+	}
+	preview := previewPath(out)
+	if err := writePNG(preview, upscale(paletted, previewScale)); err != nil {
+		return err
+	}
+
+	w, h := img.Dimensions()
+	pixels, cost, duration := painter.EstimateBlank(img)
+	log.Infof("Wrote %vx%v art to %v and a %vx preview to %v", w, h, out, previewScale, preview)
+	log.Infof("Drawing it on a blank area takes %v pixels, %v points and %v at the rate limit", pixels, cost, duration)
+	return nil
+}
+
+func upscale(img *image.Paletted, factor int) *image.Paletted {
+	b := img.Bounds()
+	out := image.NewPaletted(image.Rect(0, 0, b.Dx()*factor, b.Dy()*factor), img.Palette)
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			out.Pix[y*out.Stride+x] = img.Pix[(y/factor)*img.Stride+x/factor]
+		}
+	}
+	return out
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create file")
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "Failed to encode %v", path)
+	}
+	return f.Close()
+}
@@ -0,0 +1,171 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package painter
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/art/resource"
+	"github.com/xStrom/patriot/config"
+	"github.com/xStrom/patriot/log"
+)
+
+// Pixels where neither of two resources accepts the color the other one paints
+type conflict struct {
+	a, b   *entry
+	ra, rb *resource.Resource // The frames that disagree, the resources themselves unless animated
+	shared int                // Pixels both frames care about
+	coords []int              // Shared pixels they disagree on
+}
+
+// Finds every pair of resources that can't both be happy with some pixel, comparing every frame of animations
+func findConflicts(entries []*entry) []*conflict {
+	var conflicts []*conflict
+	for i, a := range entries {
+		for _, b := range entries[i+1:] {
+			for _, ra := range a.frames() {
+				for _, rb := range b.frames() {
+					if c := compare(ra, rb); len(c.coords) > 0 {
+						c.a, c.b = a, b
+						conflicts = append(conflicts, c)
+					}
+				}
+			}
+		}
+	}
+	return conflicts
+}
+
+func compare(ra, rb *resource.Resource) *conflict {
+	c := &conflict{ra: ra, rb: rb}
+	area := ra.Bounds().Intersect(rb.Bounds())
+	for x := area.Min.X; x < area.Max.X; x++ {
+		for y := area.Min.Y; y < area.Max.Y; y++ {
+			ca, cb := ra.Target(x, y), rb.Target(x, y)
+			if ca == art.Transparent || cb == art.Transparent {
+				continue
+			}
+			c.shared++
+			// Each paints its own target, so a color both merely accept doesn't settle anything
+			if ca != cb && ra.Acceptable(x, y)&(1<<uint(cb)) == 0 && rb.Acceptable(x, y)&(1<<uint(ca)) == 0 {
+				c.coords = append(c.coords, x|(y<<16))
+			}
+		}
+	}
+	return c
+}
+
+// Fails if resources that can be active at the same time from now on disagree without a declared winner
+func checkConflicts(entries []*entry, now time.Time) error {
+	var unresolved []*conflict
+	for _, c := range findConflicts(entries) {
+		if !c.a.cfg.Concurrent(c.b.cfg, now) {
+			log.Infof("%v and %v disagree on %v of %v shared pixels, but are never active at the same time",
+				c.a.cfg.Name, c.b.cfg.Name, len(c.coords), c.shared)
+			continue
+		}
+		winner := c.a.cfg.Winner(c.b.cfg)
+		if winner == nil {
+			x, y := c.coords[0]&0xffff, c.coords[0]>>16
+			log.Infof("%v and %v disagree on %v of %v shared pixels, e.g. at %v:%v %v wants %v and %v wants %v",
+				c.a.cfg.Name, c.b.cfg.Name, len(c.coords), c.shared,
+				x, y, c.a.cfg.Name, art.CurrentPalette().Name(c.ra.Target(x, y)), c.b.cfg.Name, art.CurrentPalette().Name(c.rb.Target(x, y)))
+			unresolved = append(unresolved, c)
+			continue
+		}
+		log.Infof("%v and %v disagree on %v of %v shared pixels, %v wins", c.a.cfg.Name, c.b.cfg.Name, len(c.coords), c.shared, winner.Name)
+	}
+	if len(unresolved) > 0 {
+		c := unresolved[0]
+		return errors.Errorf("%v unresolved overlaps, e.g. %v and %v disagree on %v pixels, list the winner in the beats of the other",
+			len(unresolved), c.a.cfg, c.b.cfg, len(c.coords))
+	}
+	return nil
+}
+
+// Has the loser of every conflict between entries that are together give up the disputed pixels,
+// taking back whatever the entries gave up before
+func resolveConflicts(entries []*entry, together func(a, b *entry) bool) {
+	for _, e := range entries {
+		for _, r := range e.frames() {
+			r.SetExcluded(nil)
+		}
+	}
+	excluded := map[*resource.Resource][]int{}
+	for _, c := range findConflicts(entries) {
+		if !together(c.a, c.b) {
+			continue
+		}
+		winner := c.a.cfg.Winner(c.b.cfg)
+		if winner == nil {
+			// checkConflicts only looks a year ahead for cron activations
+			log.Infof("%v and %v disagree on %v pixels without a winner, both keep drawing them", c.a.cfg.Name, c.b.cfg.Name, len(c.coords))
+			continue
+		}
+		loser := c.ra
+		if winner == c.a.cfg {
+			loser = c.rb
+		}
+		excluded[loser] = append(excluded[loser], c.coords...)
+	}
+	for r, coords := range excluded {
+		r.SetExcluded(coords)
+	}
+}
+
+// Whether both entries are within their activation windows
+func bothActive(a, b *entry) bool {
+	return a.active && b.active
+}
+
+// Merges the art of every resource into a canvas sized image, higher priority on top where they still disagree
+func composite(entries []*entry) *art.Image {
+	sorted := append([]*entry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].cfg.Priority > sorted[j].cfg.Priority })
+	colors := make([]int, art.CanvasWidth*art.CanvasHeight)
+	for j := range colors {
+		colors[j] = art.Transparent
+	}
+	for j := len(sorted) - 1; j >= 0; j-- {
+		r := sorted[j].res
+		b := r.Bounds()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				if c := r.Target(x, y); c != art.Transparent {
+					colors[x+y*art.CanvasWidth] = c
+				}
+			}
+		}
+	}
+	return art.NewImage(1, art.CanvasWidth, art.CanvasHeight, colors)
+}
+
+// Loads the enabled resources of the config and merges them into the art the painter will defend,
+// as if every resource that can be active at the same time as another one was.
+// The merged art is returned along with the error when only conflicts are left unresolved, so that they can be reviewed.
+func Composite(cfg *config.Config) (*art.Image, error) {
+	entries, err := newEntries(cfg)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	err = checkConflicts(entries, now)
+	resolveConflicts(entries, func(a, b *entry) bool { return a.cfg.Concurrent(b.cfg, now) })
+	return composite(entries), err
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package painter
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/xStrom/patriot/art"
+)
+
+func TestFindConflicts(t *testing.T) {
+	tests := []struct {
+		name      string
+		acceptA   string
+		acceptB   string
+		conflicts bool
+	}{
+		{"no rules", `{}`, `{}`, true},
+		{"only a third color in common", `{"White": ["LightGray"]}`, `{"Black": ["LightGray"]}`, true},
+		{"A accepts what B paints", `{"White": ["Black"]}`, `{}`, false},
+		{"B accepts what A paints", `{}`, `{"Black": ["White"]}`, false},
+	}
+	for _, tt := range tests {
+		// The same text, White for A and Black for B
+		cfg := testConfig(t, `{"resources": [
+			{"name": "A", "text": "I", "x": 10, "y": 10, "color": "White", "accept": `+tt.acceptA+`},
+			{"name": "B", "text": "I", "x": 10, "y": 10, "color": "Black", "accept": `+tt.acceptB+`}]}`)
+		entries, err := newEntries(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conflicts := findConflicts(entries); (len(conflicts) > 0) != tt.conflicts {
+			t.Errorf("%v: got %v conflicts, want conflicts %v", tt.name, len(conflicts), tt.conflicts)
+		}
+	}
+}
+
+// Config of the same text at the same spot, White for A and Black for B, each active within its window
+func windowedConfig(windowA, windowB string, beats string) string {
+	return `{"resources": [
+		{"name": "A", "text": "I", "x": 10, "y": 10, "color": "White"` + windowA + `},
+		{"name": "B", "text": "I", "x": 10, "y": 10, "color": "Black", "beats": [` + beats + `]` + windowB + `}]}`
+}
+
+func window(from, to time.Duration) string {
+	now := time.Now()
+	return `, "start": "` + now.Add(from).Format(time.RFC3339) + `", "end": "` + now.Add(to).Format(time.RFC3339) + `"`
+}
+
+func TestCheckConflictsOnlyWhenConcurrent(t *testing.T) {
+	tests := []struct {
+		name             string
+		windowA, windowB string
+		unresolved       bool
+	}{
+		{"always", "", "", true},
+		{"overlapping windows", window(time.Hour, 3*time.Hour), window(2*time.Hour, 4*time.Hour), true},
+		{"separate windows", window(time.Hour, 2*time.Hour), window(3*time.Hour, 4*time.Hour), false},
+		{"window in the past", window(-2*time.Hour, -time.Hour), "", false},
+		// Daily at the hour half a day away
+		{"cron outside the window", `, "cron": "0 ` + strconv.Itoa((time.Now().Hour()+12)%24) + ` * * *", "duration": "1h"`, window(0, time.Hour), false},
+		{"cron within the window", `, "cron": "0 ` + strconv.Itoa((time.Now().Hour()+12)%24) + ` * * *", "duration": "1h"`, window(0, 24*time.Hour), true},
+	}
+	for _, tt := range tests {
+		cfg := testConfig(t, windowedConfig(tt.windowA, tt.windowB, ""))
+		entries, err := newEntries(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkConflicts(entries, time.Now()); (err != nil) != tt.unresolved {
+			t.Errorf("%v: got %v, want unresolved %v", tt.name, err, tt.unresolved)
+		}
+	}
+}
+
+func TestResolveConflictsFollowsActivation(t *testing.T) {
+	// B beats A, but only while B is active
+	cfg := testConfig(t, windowedConfig("", window(time.Hour, 2*time.Hour), `"A"`))
+	entries, err := newEntries(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := entries[0], entries[1]
+	given := func() int {
+		n := 0
+		bounds := a.res.Bounds()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				if b.res.Target(x, y) != art.Transparent && a.res.Target(x, y) == art.Transparent {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	for _, active := range []bool{false, true, false} {
+		a.active, b.active = true, active
+		resolveConflicts(entries, bothActive)
+		if n := given(); (n > 0) != active {
+			t.Errorf("With B active %v A gave up %v pixels", active, n)
+		}
+	}
+}
@@ -21,38 +21,22 @@ import (
 	"time"
 
 	"github.com/xStrom/patriot/art"
-	"github.com/xStrom/patriot/art/resource"
+	"github.com/xStrom/patriot/config"
 	"github.com/xStrom/patriot/log"
 	"github.com/xStrom/patriot/sp"
 	"github.com/xStrom/patriot/work/shutdown"
 )
 
-type ResourceInfo struct {
-	x        int
-	y        int
-	filepath string
-}
-
-var resourceInfos = []*ResourceInfo{
-//&ResourceInfo{74, 35, "data/estflag.png"},     // Estonian flag [Classic above the fold flag]
-//&ResourceInfo{150, 284, "data/dota.png"},      // Dota 2 logo
-//&ResourceInfo{0, 0, "data/acdc.png"},          // AC/DC logo [Top left corner]
-//&ResourceInfo{735, 875, "data/estville2.png"}, // Estville [Bottom right project]
-//&ResourceInfo{74, 35, "data/estcows.png"},     // Estonian flag with 3rd party cows [Classic above the fold flag position]
-}
-
-func Work(wg *sync.WaitGroup, image *art.Image) {
+func Work(wg *sync.WaitGroup, image *art.Image, canvas sp.Canvas, cfg *config.Config) {
 	// Load resources
 	log.Infof("Loading resources ..")
-	resources := make([]*resource.Resource, 0, len(resourceInfos))
-	for _, ri := range resourceInfos {
-		r, err := resource.New(ri.x, ri.y, ri.filepath)
-		if err != nil {
-			panic(fmt.Sprintf("Failed to load resource: %v", err))
-		}
-		resources = append(resources, r)
+	set, err := newResourceSet(cfg, image)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load resources: %v", err))
 	}
+	go set.watch()
 
+	stats := newDrawStats()
 	inFlight := map[int]bool{}
 	inFlightLock := sync.Mutex{}
 	for {
@@ -72,24 +56,29 @@ func Work(wg *sync.WaitGroup, image *art.Image) {
 		shutdown.ShutdownLock.RUnlock()
 
 		// Sleep until we can perform the next move
-		sleepUntilNextMove()
+		sleepUntilNextMove(set.minCost())
+
+		set.tick()
 
 		inFlightLock.Lock()
 
-		var p *art.Pixel
-		for _, r := range resources {
-			if p = r.GetWork(image, inFlight); p != nil {
-				break
+		moves := set.plan(inFlight, remainingBudget())
+		if len(moves) > 1 {
+			cost := 0
+			for _, m := range moves {
+				cost += m.cost
 			}
+			log.Infof("Planned %v pixels for %v points", len(moves), cost)
 		}
 
-		if p != nil {
+		for _, m := range moves {
+			p := m.p
+			stats.add(m.e)
 			inFlight[p.X|(p.Y<<16)] = true
-			cost := drawCallCost(image.At(p.X, p.Y))
-			cs := addCycleCost(cost)
+			cs := addCycleCost(m.cost)
 			go func(p *art.Pixel, cs int64, cost int) {
 				//log.Infof("Requesting draw of %v:%v - %v", p.X, p.Y, p.C)
-				if err, statusCode := sp.DrawPixel(p.X, p.Y, p.C); err != nil {
+				if err, statusCode := canvas.DrawPixel(p.X, p.Y, p.C); err != nil {
 					// Don't remove the cycle cost in case of 403, because that means we hit the server rate limiting
 					if statusCode != http.StatusForbidden {
 						removeCycleCost(cs, cost)
@@ -100,30 +89,49 @@ func Work(wg *sync.WaitGroup, image *art.Image) {
 				inFlightLock.Lock()
 				delete(inFlight, p.X|(p.Y<<16))
 				inFlightLock.Unlock()
-			}(p, cs, cost)
+			}(p, cs, m.cost)
 		}
 
 		inFlightLock.Unlock()
 
 		// Prevent hot spin if there's nothing to do
-		if p == nil {
+		if len(moves) == 0 {
 			time.Sleep(1 * time.Second)
 		}
 	}
 }
 
+// Rate limit of the server, also enforced by the simulator
 const (
-	scorePerWindow     = 30
-	scoreWindowSecs    = 10
-	paintOverWhiteCost = 2
-	paintOverOtherCost = 5
+	ScorePerWindow     = 30
+	ScoreWindowSecs    = 10
+	PaintOverWhiteCost = 2
+	PaintOverOtherCost = 5
 )
 
-func drawCallCost(oldColor int) int {
+// Returns the points it costs to paint over a pixel of oldColor
+func DrawCallCost(oldColor int) int {
 	if oldColor == art.White {
-		return paintOverWhiteCost
+		return PaintOverWhiteCost
 	}
-	return paintOverOtherCost
+	return PaintOverOtherCost
+}
+
+// Returns the number of pixels to draw, the points they cost and how long that takes at the
+// rate limit when drawing the art onto a blank canvas
+func EstimateBlank(img *art.Image) (int, int, time.Duration) {
+	w, h := img.Dimensions()
+	pixels := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if c := img.At(x, y); c >= 0 && c != art.Transparent && c != art.White {
+				pixels++
+			}
+		}
+	}
+	cost := pixels * DrawCallCost(art.White)
+	windows := (cost + ScorePerWindow - 1) / ScorePerWindow
+	return pixels, cost, time.Duration(windows*ScoreWindowSecs) * time.Second
 }
 
 var cycleCost int
@@ -137,6 +145,12 @@ func addCycleCost(cost int) int64 {
 	return cycleStart
 }
 
+func remainingBudget() int {
+	cycleLock.Lock()
+	defer cycleLock.Unlock()
+	return ScorePerWindow - cycleCost
+}
+
 func removeCycleCost(start int64, cost int) {
 	cycleLock.Lock()
 	defer cycleLock.Unlock()
@@ -145,12 +159,13 @@ func removeCycleCost(start int64, cost int) {
 	}
 }
 
-func sleepUntilNextMove() {
+// Sleeps until there's at least minCost left in the budget
+func sleepUntilNextMove(minCost int) {
 	for {
 		cycleLock.Lock()
 
 		now := time.Now().Unix()
-		if cycleStart+scoreWindowSecs <= now {
+		if cycleStart+ScoreWindowSecs <= now {
 			cycleStart = now
 			cycleCost = 0
 			//log.Infof("New cycle started at %v", cycleStart)
@@ -159,8 +174,8 @@ func sleepUntilNextMove() {
 		}
 
 		// Can we do the next move?
-		if scorePerWindow-cycleCost >= paintOverOtherCost {
-			//log.Infof("Can still do another move (%v/%v)", cycleCost, scorePerWindow)
+		if ScorePerWindow-cycleCost >= minCost {
+			//log.Infof("Can still do another move (%v/%v)", cycleCost, ScorePerWindow)
 			cycleLock.Unlock()
 			return
 		}
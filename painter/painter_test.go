@@ -0,0 +1,145 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package painter
+
+import (
+	"encoding/json"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/config"
+	"github.com/xStrom/patriot/realtime"
+	"github.com/xStrom/patriot/sp/sptest"
+	"github.com/xStrom/patriot/work/shutdown"
+)
+
+const x0, y0 = 10, 20
+
+func TestWorkDefendsArt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "patriot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A black line 3 pixels tall
+	path := filepath.Join(dir, "line.png")
+	writeImage(t, path, image.NewPaletted(image.Rect(0, 0, 1, 3), art.ColorPalette()[art.Black:art.Black+1]))
+	cfg := loadConfig(t, dir, []map[string]interface{}{{"path": path, "x": x0, "y": y0}})
+
+	canvas := sptest.NewCanvas()
+	// Vandalism the keyframe already has
+	canvas.Edit(x0, y0+1, art.Red)
+	img := &art.Image{}
+	data, version, err := canvas.FetchImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := img.ParseKeyframe(version, data, false); err != nil {
+		t.Fatal(err)
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go realtime.Realtime(wg, img, canvas)
+	go Work(wg, img, canvas, cfg)
+
+	waitIntact(t, canvas)
+	drawn := len(canvas.Draws())
+
+	// Vandalism that only arrives over realtime
+	canvas.Edit(x0, y0+2, art.Red)
+	waitIntact(t, canvas)
+	if n := len(canvas.Draws()) - drawn; n != 1 {
+		t.Errorf("Repairing one pixel took %v draws", n)
+	}
+
+	shutdown.ShutdownLock.Lock()
+	shutdown.Shutdown = true
+	shutdown.ShutdownLock.Unlock()
+	defer func() {
+		shutdown.ShutdownLock.Lock()
+		shutdown.Shutdown = false
+		shutdown.ShutdownLock.Unlock()
+	}()
+	realtime.Shutdown()
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(15 * time.Second):
+		t.Fatal("Painter didn't shut down")
+	}
+}
+
+// Writes a config with the resources to dir and loads it
+func loadConfig(t *testing.T, dir string, resources []map[string]interface{}) *config.Config {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"resources": resources})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "resources.json")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func writeImage(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Waits until the canvas has the black line at x0, y0
+func waitIntact(t *testing.T, canvas *sptest.Canvas) {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		broken := 0
+		for y := y0; y < y0+3; y++ {
+			if canvas.At(x0, y) != art.Black {
+				broken++
+			}
+		}
+		if broken == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v pixels are still broken", broken)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package painter
+
+import (
+	"github.com/xStrom/patriot/art"
+)
+
+// How many scheduled pixels the cost aware planner picks from
+const planPoolSize = 2 * ScorePerWindow / PaintOverWhiteCost
+
+type move struct {
+	e     *entry
+	p     *art.Pixel
+	cost  int
+	value int
+}
+
+// Returns the moves to perform within the budget.
+// Without cost awareness that's simply the next scheduled pixel,
+// otherwise a pool of scheduled pixels is planned to repair the most per point.
+func (s *resourceSet) plan(ignorePixels map[int]bool, budget int) []move {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entries := s.activeEntries()
+	if !s.cfg.CostAware {
+		e, p := s.scheduler.next(entries, ignorePixels)
+		if p == nil {
+			return nil
+		}
+		cost := DrawCallCost(s.image.At(p.X, p.Y))
+		s.scheduler.drawn(entries, e, cost, ignorePixels)
+		return []move{{e, p, cost, 1}}
+	}
+
+	ignore := make(map[int]bool, len(ignorePixels)+planPoolSize)
+	for k, v := range ignorePixels {
+		ignore[k] = v
+	}
+	// Every candidate is charged as if it got painted, so that the pool is shared like the budget would be
+	credits := make([]int, len(entries))
+	for i, e := range entries {
+		credits[i] = e.credit
+	}
+	pool := make([]move, 0, planPoolSize)
+	for len(pool) < planPoolSize {
+		e, p := s.scheduler.next(entries, ignore)
+		if p == nil {
+			break
+		}
+		ignore[p.X|(p.Y<<16)] = true
+		m := move{e, p, DrawCallCost(s.image.At(p.X, p.Y)), e.res.Importance(p.X, p.Y)}
+		s.scheduler.drawn(entries, e, m.cost, ignore)
+		pool = append(pool, m)
+	}
+	// Only the moves that get painted count towards the scheduling shares
+	for i, e := range entries {
+		e.credit = credits[i]
+	}
+	picked := knapsack(pool, budget)
+	for _, m := range picked {
+		s.scheduler.drawn(entries, m.e, m.cost, ignorePixels)
+	}
+	return picked
+}
+
+// Picks the subset of moves with the most total value that fits in the budget, preferring earlier moves on ties
+func knapsack(moves []move, budget int) []move {
+	if budget <= 0 || len(moves) == 0 {
+		return nil
+	}
+	// best[i][b] is the most value achievable with moves[i:] and budget b
+	best := make([][]int, len(moves)+1)
+	best[len(moves)] = make([]int, budget+1)
+	for i := len(moves) - 1; i >= 0; i-- {
+		best[i] = make([]int, budget+1)
+		for b := 0; b <= budget; b++ {
+			best[i][b] = best[i+1][b]
+			if m := moves[i]; m.cost <= b && best[i+1][b-m.cost]+m.value > best[i][b] {
+				best[i][b] = best[i+1][b-m.cost] + m.value
+			}
+		}
+	}
+	var picked []move
+	b := budget
+	for i, m := range moves {
+		if m.cost <= b && best[i][b] == best[i+1][b-m.cost]+m.value {
+			picked = append(picked, m)
+			b -= m.cost
+		}
+	}
+	return picked
+}
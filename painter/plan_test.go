@@ -0,0 +1,95 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package painter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/config"
+)
+
+func testConfig(t *testing.T, data string) *config.Config {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "patriot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "resources.json")
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// Loads the config JSON against a canvas that's White apart from the Red rows from redY0 to redY1
+func testResourceSet(t *testing.T, data string, redY0, redY1 int) *resourceSet {
+	t.Helper()
+	cfg := testConfig(t, data)
+	colors := make([]int, art.CanvasWidth*art.CanvasHeight)
+	for j := redY0 * art.CanvasWidth; j < redY1*art.CanvasWidth; j++ {
+		colors[j] = art.Red
+	}
+	s, err := newResourceSet(cfg, art.NewImage(1, art.CanvasWidth, art.CanvasHeight, colors))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPlanWeightedShares(t *testing.T) {
+	for _, costAware := range []bool{false, true} {
+		// Repairing WORLD costs 5 points a pixel instead of 2, which the planner prefers to avoid
+		s := testResourceSet(t, `{"scheduling": "weighted", "resources": [
+			{"text": "HELLO", "x": 10, "y": 10},
+			{"text": "WORLD", "x": 10, "y": 30}]}`, 30, 40)
+		s.cfg.CostAware = costAware
+		points := map[string]int{}
+		total := 0
+		for total < 5000 {
+			// Nothing gets repaired, so every plan has all pixels to pick from
+			for _, m := range s.plan(nil, ScorePerWindow) {
+				points[m.e.cfg.Name] += m.cost
+				total += m.cost
+			}
+		}
+		if share := float64(points["WORLD"]) / float64(total); share < 0.45 || share > 0.55 {
+			t.Errorf("Cost aware %v: WORLD with half of the weight got %.2f of %v points", costAware, share, total)
+		}
+	}
+}
+
+func TestPlanPoolSharesResources(t *testing.T) {
+	// Both cost the same, so the planner has no reason to prefer either
+	s := testResourceSet(t, `{"scheduling": "weighted", "resources": [
+		{"text": "HELLO", "x": 10, "y": 10},
+		{"text": "WORLD", "x": 10, "y": 30}]}`, 0, 0)
+	s.cfg.CostAware = true
+	counts := map[string]int{}
+	for _, m := range s.plan(nil, ScorePerWindow) {
+		counts[m.e.cfg.Name]++
+	}
+	if counts["HELLO"] == 0 || counts["WORLD"] == 0 {
+		t.Errorf("A single plan only had pixels of one resource to pick from: %v", counts)
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package painter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/art/font"
+	"github.com/xStrom/patriot/art/quantize"
+	"github.com/xStrom/patriot/art/resource"
+	"github.com/xStrom/patriot/config"
+	"github.com/xStrom/patriot/log"
+	"github.com/xStrom/patriot/work/shutdown"
+)
+
+const reloadCheckInterval = 2 * time.Second
+
+// The currently defended resources, swapped out as a whole when the config changes
+type resourceSet struct {
+	lock      sync.RWMutex
+	image     *art.Image
+	cfg       *config.Config
+	entries   []*entry
+	scheduler scheduler
+	stamps    map[string]fileStamp
+}
+
+type entry struct {
+	cfg    *config.Resource
+	res    *resource.Resource // The frame being drawn when animated
+	anim   *resource.Animation
+	active bool // Whether it's within its activation window
+	credit int  // Used by weighted round-robin
+}
+
+// Returns every frame of the art
+func (e *entry) frames() []*resource.Resource {
+	if e.anim != nil {
+		return e.anim.Frames()
+	}
+	return []*resource.Resource{e.res}
+}
+
+func (e *entry) watch(image *art.Image) {
+	if e.anim != nil {
+		e.anim.Watch(image)
+	} else {
+		e.res.Watch(image)
+	}
+}
+
+func (e *entry) unwatch() {
+	if e.anim != nil {
+		e.anim.Unwatch()
+	} else {
+		e.res.Unwatch()
+	}
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func newResourceSet(cfg *config.Config, image *art.Image) (*resourceSet, error) {
+	entries, err := loadResources(cfg, image)
+	if err != nil {
+		return nil, err
+	}
+	return &resourceSet{image: image, cfg: cfg, entries: entries, scheduler: newScheduler(cfg.Scheduling), stamps: stampFiles(cfg)}, nil
+}
+
+// The cheapest move worth waiting for, only cost aware planning can make use of cheap moves
+func (s *resourceSet) minCost() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.cfg.CostAware {
+		return PaintOverWhiteCost
+	}
+	return PaintOverOtherCost
+}
+
+// Loads the enabled resources and has them watch the image for broken pixels
+func loadResources(cfg *config.Config, image *art.Image) ([]*entry, error) {
+	entries, err := newEntries(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkConflicts(entries, time.Now()); err != nil {
+		return nil, err
+	}
+	resolveConflicts(entries, bothActive)
+	for _, e := range entries {
+		e.watch(image)
+	}
+	logShares(cfg.Scheduling, entries)
+	return entries, nil
+}
+
+// Loads the enabled resources, with their order and accepted colors set up
+func newEntries(cfg *config.Config) ([]*entry, error) {
+	enabled := cfg.Enabled()
+	entries := make([]*entry, 0, len(enabled))
+	for _, rc := range enabled {
+		images, dwell, err := loadFrames(rc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to load %v", rc)
+		}
+		rules, err := rc.AcceptColors()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid accept rules of %v", rc)
+		}
+		frames := make([]*resource.Resource, len(images))
+		for i, img := range images {
+			r := resource.NewFromImage(rc.X, rc.Y, img)
+			if err := r.SetOrder(rc.Order, rc.Mask); err != nil {
+				return nil, errors.Wrapf(err, "Failed to order %v", rc)
+			}
+			if err := r.SetAccept(rules, rc.Alternates); err != nil {
+				return nil, errors.Wrapf(err, "Failed to load alternates of %v", rc)
+			}
+			frames[i] = r
+		}
+		e := &entry{cfg: rc, res: frames[0], active: rc.Active(time.Now())}
+		if len(frames) > 1 {
+			e.anim = resource.NewAnimation(frames, dwell, rc.Complete, rc.Timeout.Duration)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Loads the art of the resource, one image per frame along with how long each frame is shown
+func loadFrames(rc *config.Resource) ([]*art.Image, []time.Duration, error) {
+	if rc.Text != "" {
+		fg, bg, err := rc.TextColors()
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*art.Image{font.Render(rc.Text, fg, bg)}, []time.Duration{0}, nil
+	}
+	if len(rc.Frames) > 0 {
+		images := make([]*art.Image, len(rc.Frames))
+		dwell := make([]time.Duration, len(rc.Frames))
+		for i, f := range rc.Frames {
+			img, err := loadImage(f.Path, rc.Quantize)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "Failed to load frame %v", f.Path)
+			}
+			images[i], dwell[i] = img, f.Dwell.Duration
+		}
+		return images, dwell, nil
+	}
+	if strings.EqualFold(filepath.Ext(rc.Path), ".gif") {
+		mode := rc.Quantize
+		if mode == "" {
+			mode = quantize.Exact
+		}
+		return quantize.LoadGIF(rc.Path, mode)
+	}
+	img, err := loadImage(rc.Path, rc.Quantize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []*art.Image{img}, []time.Duration{0}, nil
+}
+
+func loadImage(path, mode string) (*art.Image, error) {
+	if mode == "" {
+		return resource.LoadImage(path)
+	}
+	return quantize.Load(path, mode)
+}
+
+// Logs the budget share each resource gets when everything needs work
+func logShares(scheduling string, entries []*entry) {
+	total := map[int]int{}
+	for _, e := range entries {
+		total[e.cfg.Priority] += e.cfg.Weight
+	}
+	log.Infof("Scheduling %v resources %v", len(entries), scheduling)
+	for _, e := range entries {
+		rc := e.cfg
+		if rc.Scheduled() && !e.active {
+			log.Infof("%v is outside its activation window for now", rc)
+		}
+		if scheduling == config.Strict {
+			log.Infof("Loaded %v at %v,%v with priority %v, %v order", rc, rc.X, rc.Y, rc.Priority, rc.Order)
+		} else {
+			share := 100 * float64(rc.Weight) / float64(total[rc.Priority])
+			log.Infof("Loaded %v at %v,%v with priority %v, weight %v (%.0f%% of priority), %v order", rc, rc.X, rc.Y, rc.Priority, rc.Weight, share, rc.Order)
+		}
+	}
+}
+
+// Stamps the config file and every image it references
+func stampFiles(cfg *config.Config) map[string]fileStamp {
+	paths := []string{cfg.Path()}
+	for _, rc := range cfg.Resources {
+		paths = append(paths, rc.Paths()...)
+		if rc.Mask != "" {
+			paths = append(paths, rc.Mask)
+		}
+		if rc.Alternates != "" {
+			paths = append(paths, rc.Alternates)
+		}
+	}
+	stamps := make(map[string]fileStamp, len(paths))
+	for _, path := range paths {
+		stamps[path] = current(path)
+	}
+	return stamps
+}
+
+// Stamps the file as it is now, the zero stamp if it doesn't exist
+func current(path string) fileStamp {
+	if fi, err := os.Stat(path); err == nil {
+		return fileStamp{fi.ModTime(), fi.Size()}
+	}
+	return fileStamp{}
+}
+
+func (s *resourceSet) changed() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for path, stamp := range s.stamps {
+		if current(path) != stamp {
+			return true
+		}
+	}
+	return false
+}
+
+// Polls the config and referenced pngs for changes and reloads the resources when they do
+func (s *resourceSet) watch() {
+	for {
+		time.Sleep(reloadCheckInterval)
+
+		shutdown.ShutdownLock.RLock()
+		if shutdown.Shutdown {
+			shutdown.ShutdownLock.RUnlock()
+			return
+		}
+		shutdown.ShutdownLock.RUnlock()
+
+		if s.changed() {
+			s.reload()
+		}
+	}
+}
+
+func (s *resourceSet) reload() {
+	s.lock.RLock()
+	path := s.cfg.Path()
+	old := s.cfg
+	s.lock.RUnlock()
+
+	log.Infof("Reloading resources from %v ..", path)
+	cfg, err := config.Load(path)
+	var entries []*entry
+	if err == nil {
+		entries, err = loadResources(cfg, s.image)
+	}
+	if err != nil {
+		// Keep defending what we have, but don't retry until the files change again
+		log.Infof("Failed to reload resources, keeping the old ones: %v", err)
+		stamps := stampFiles(old)
+		// Files only the new config references may be what's missing, so they get watched as well
+		if cfg, err := config.Parse(path); err == nil {
+			for path, stamp := range stampFiles(cfg) {
+				stamps[path] = stamp
+			}
+		}
+		s.lock.Lock()
+		s.stamps = stamps
+		s.lock.Unlock()
+		return
+	}
+	logChanges(old, cfg)
+
+	s.lock.Lock()
+	oldEntries := s.entries
+	s.cfg = cfg
+	s.entries = entries
+	s.scheduler = newScheduler(cfg.Scheduling)
+	s.stamps = stampFiles(cfg)
+	s.lock.Unlock()
+	for _, e := range oldEntries {
+		e.unwatch()
+	}
+}
+
+func logChanges(old, cfg *config.Config) {
+	before := map[string]*config.Resource{}
+	for _, rc := range old.Enabled() {
+		before[rc.Name] = rc
+	}
+	for _, rc := range cfg.Enabled() {
+		if prev, ok := before[rc.Name]; !ok {
+			log.Infof("Added %v at %v,%v", rc, rc.X, rc.Y)
+		} else if prev.X != rc.X || prev.Y != rc.Y {
+			log.Infof("Moved %v from %v,%v to %v,%v", rc, prev.X, prev.Y, rc.X, rc.Y)
+		}
+		delete(before, rc.Name)
+	}
+	for _, rc := range before {
+		log.Infof("Removed %v", rc)
+	}
+}
+
+// Starts and stops defending resources as they enter and leave their activation windows
+// and moves animations on to their next frame when it's time
+func (s *resourceSet) tick() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	changed := false
+	for _, e := range s.entries {
+		if active := e.cfg.Active(now); active != e.active {
+			e.active = active
+			changed = true
+			if active {
+				log.Infof("Activated %v", e.cfg)
+			} else {
+				log.Infof("Deactivated %v", e.cfg)
+			}
+		}
+	}
+	if changed {
+		// Overlaps only need settling between resources that are active together
+		resolveConflicts(s.entries, bothActive)
+	}
+	for _, e := range s.entries {
+		if !e.active || e.anim == nil || !e.anim.Tick(now) {
+			continue
+		}
+		var i int
+		e.res, i = e.anim.Current()
+		log.Infof("Switched %v to frame %v/%v", e.cfg, i+1, len(e.anim.Frames()))
+	}
+}
+
+// Returns the entries within their activation window
+func (s *resourceSet) activeEntries() []*entry {
+	active := make([]*entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		if e.active {
+			active = append(active, e)
+		}
+	}
+	return active
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package painter
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/config"
+	"github.com/xStrom/patriot/log"
+)
+
+const statsInterval = time.Minute
+
+type scheduler interface {
+	// Picks the next pixel to repair without committing to it, so that it can be called for candidates
+	next(entries []*entry, ignorePixels map[int]bool) (*entry, *art.Pixel)
+	// Commits to repairing a pixel of e that next picked, for cost points of the budget
+	drawn(entries []*entry, e *entry, cost int, ignorePixels map[int]bool)
+}
+
+func newScheduler(scheduling string) scheduler {
+	switch scheduling {
+	case config.Weighted:
+		return &weightedScheduler{}
+	case config.Damage:
+		return &damageScheduler{}
+	}
+	return &strictScheduler{}
+}
+
+// Returns the entries grouped by priority, highest priority first
+func byPriority(entries []*entry) [][]*entry {
+	sorted := make([]*entry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].cfg.Priority > sorted[j].cfg.Priority })
+	var tiers [][]*entry
+	for i, e := range sorted {
+		if i == 0 || e.cfg.Priority != sorted[i-1].cfg.Priority {
+			tiers = append(tiers, nil)
+		}
+		tiers[len(tiers)-1] = append(tiers[len(tiers)-1], e)
+	}
+	return tiers
+}
+
+type strictScheduler struct{}
+
+func (s *strictScheduler) next(entries []*entry, ignorePixels map[int]bool) (*entry, *art.Pixel) {
+	for _, tier := range byPriority(entries) {
+		for _, e := range tier {
+			if p := e.res.GetWork(ignorePixels); p != nil {
+				return e, p
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (s *strictScheduler) drawn(entries []*entry, e *entry, cost int, ignorePixels map[int]bool) {}
+
+// Smooth weighted round-robin over points of the budget, only resources with work take part
+type weightedScheduler struct{}
+
+func (s *weightedScheduler) next(entries []*entry, ignorePixels map[int]bool) (*entry, *art.Pixel) {
+	for _, tier := range byPriority(entries) {
+		var best *entry
+		var bestPixel *art.Pixel
+		for _, e := range tier {
+			p := e.res.GetWork(ignorePixels)
+			if p == nil {
+				continue
+			}
+			if best == nil || e.credit+e.cfg.Weight > best.credit+best.cfg.Weight {
+				best, bestPixel = e, p
+			}
+		}
+		if best != nil {
+			return best, bestPixel
+		}
+	}
+	return nil, nil
+}
+
+// Every resource of the tier with work earns its weight per point and e pays for all of it,
+// so that a resource whose pixels cost more gets fewer of them rather than more of the budget
+func (s *weightedScheduler) drawn(entries []*entry, e *entry, cost int, ignorePixels map[int]bool) {
+	total := 0
+	for _, o := range entries {
+		if o.cfg.Priority != e.cfg.Priority || o != e && o.res.GetWork(ignorePixels) == nil {
+			continue
+		}
+		o.credit += o.cfg.Weight * cost
+		total += o.cfg.Weight * cost
+	}
+	e.credit -= total
+}
+
+// Picks randomly, proportional to weight times the number of broken pixels
+type damageScheduler struct{}
+
+func (s *damageScheduler) next(entries []*entry, ignorePixels map[int]bool) (*entry, *art.Pixel) {
+	for _, tier := range byPriority(entries) {
+		scores := make([]int, len(tier))
+		total := 0
+		for i, e := range tier {
+			scores[i] = e.cfg.Weight * e.res.Damage(ignorePixels)
+			total += scores[i]
+		}
+		if total == 0 {
+			continue
+		}
+		pick := rand.Intn(total)
+		for i, e := range tier {
+			if pick < scores[i] {
+				return e, e.res.GetWork(ignorePixels)
+			}
+			pick -= scores[i]
+		}
+	}
+	return nil, nil
+}
+
+func (s *damageScheduler) drawn(entries []*entry, e *entry, cost int, ignorePixels map[int]bool) {}
+
+// Counts draws per resource and logs the split periodically
+type drawStats struct {
+	counts map[string]int
+	total  int
+	since  time.Time
+}
+
+func newDrawStats() *drawStats {
+	return &drawStats{counts: map[string]int{}, since: time.Now()}
+}
+
+func (d *drawStats) add(e *entry) {
+	// Text and frame list resources have no path
+	d.counts[e.cfg.String()]++
+	d.total++
+	if time.Since(d.since) < statsInterval {
+		return
+	}
+	for name, n := range d.counts {
+		log.Infof("Drew %v pixels (%.0f%%) of %v in the last %v", n, 100*float64(n)/float64(d.total), name, statsInterval)
+	}
+	d.counts = map[string]int{}
+	d.total = 0
+	d.since = time.Now()
+}
@@ -0,0 +1,37 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package painter
+
+import (
+	"testing"
+)
+
+func TestDrawStatsPerResource(t *testing.T) {
+	s := testResourceSet(t, `{"resources": [
+		{"text": "HELLO", "x": 10, "y": 10},
+		{"text": "WORLD", "x": 10, "y": 30}]}`, 0, 0)
+	d := newDrawStats()
+	for _, e := range s.entries {
+		d.add(e)
+	}
+	if len(d.counts) != 2 {
+		t.Errorf("Counted draws of 2 text resources as %v", d.counts)
+	}
+	for name := range d.counts {
+		if name == "" {
+			t.Error("Counted draws without a name")
+		}
+	}
+}
@@ -15,17 +15,86 @@
 package main
 
 import (
+	"flag"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
 
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/config"
 	"github.com/xStrom/patriot/log"
 	"github.com/xStrom/patriot/realtime"
+	"github.com/xStrom/patriot/record"
+	"github.com/xStrom/patriot/sp"
 	"github.com/xStrom/patriot/work"
 	"github.com/xStrom/patriot/work/shutdown"
 )
 
+var canvasBackend = flag.String("canvas", sp.DefaultBackend, "Canvas backend to use")
+var canvasURL = flag.String("url", sp.DefaultURL, "Base URL of the canvas server")
+var resourcesPath = flag.String("resources", "resources.json", "Config file listing the art to defend")
+var debugAddr = flag.String("debug-addr", "", "Address to serve metrics on at /debug/vars, e.g. localhost:6060")
+var recordPath = flag.String("record", "", "Append every keyframe and realtime message to this log")
+var replayPath = flag.String("replay", "", "Replay a log written with -record instead of connecting to the canvas, drawing does nothing")
+var replaySpeed = flag.Float64("speed", 1, "How many times faster than recorded to replay, 0 for as fast as possible")
+var palettePath = flag.String("palette", "", "Palette of the canvas as .act, .gpl, .json or a hex list, defaults to the josephg.com palette")
+
+var commands = map[string]func(args []string){
+	"sim":       simCommand,
+	"convert":   convertCommand,
+	"place":     placeCommand,
+	"check":     checkCommand,
+	"timelapse": timelapseCommand,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+	flag.Parse()
+	usePalette(*palettePath)
+
+	if *debugAddr != "" {
+		go func() {
+			log.Infof("Serving metrics on http://%v/debug/vars", *debugAddr)
+			if err := http.ListenAndServe(*debugAddr, nil); err != nil {
+				log.Infof("Failed to serve metrics: %v", err)
+			}
+		}()
+	}
+
+	var canvas sp.Canvas
+	var err error
+	if *replayPath != "" {
+		canvas, err = record.NewReplay(*replayPath, *replaySpeed)
+	} else {
+		canvas, err = sp.New(*canvasBackend, *canvasURL)
+	}
+	if err != nil {
+		log.Infof("Failed to set up canvas: %v", err)
+		os.Exit(1)
+	}
+	if *recordPath != "" {
+		w, err := record.Create(*recordPath)
+		if err != nil {
+			log.Infof("Failed to start recording: %v", err)
+			os.Exit(1)
+		}
+		defer w.Close()
+		log.Infof("Recording to %v", *recordPath)
+		canvas = record.NewRecorder(canvas, w)
+	}
+
+	cfg, err := config.Load(*resourcesPath)
+	if err != nil {
+		log.Infof("Failed to load resources: %v", err)
+		os.Exit(1)
+	}
+
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 
@@ -33,7 +102,7 @@ func main() {
 
 	log.Infof("Launching work engine ...")
 	wg.Add(1)
-	go work.Work(wg)
+	go work.Work(wg, &art.Image{}, canvas, cfg)
 
 mainLoop:
 	for {
@@ -52,3 +121,17 @@ mainLoop:
 	wg.Wait()
 	log.Infof("Clean shutdown done :>")
 }
+
+// Switches to the palette at path, exits if it can't be loaded
+func usePalette(path string) {
+	if path == "" {
+		return
+	}
+	p, err := art.LoadPalette(path)
+	if err != nil {
+		log.Infof("Failed to load palette: %v", err)
+		os.Exit(1)
+	}
+	log.Infof("Using palette %v with %v colors", path, p.Len())
+	art.UsePalette(p)
+}
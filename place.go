@@ -0,0 +1,132 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/art/quantize"
+	"github.com/xStrom/patriot/log"
+	"github.com/xStrom/patriot/place"
+	"github.com/xStrom/patriot/realtime"
+	"github.com/xStrom/patriot/sp"
+	"github.com/xStrom/patriot/work"
+	"github.com/xStrom/patriot/work/shutdown"
+)
+
+func placeCommand(args []string) {
+	fs := flag.NewFlagSet("place", flag.ExitOnError)
+	resourcePath := fs.String("resource", "", "Art to find a spot for")
+	mode := fs.String("quantize", quantize.Exact, "How to map off-palette colors of the art, see the resource quantize option")
+	keyframe := fs.String("keyframe", "", "Canvas png to search instead of the live canvas")
+	backend := fs.String("canvas", sp.DefaultBackend, "Canvas backend to use")
+	url := fs.String("url", sp.DefaultURL, "Base URL of the canvas server")
+	observe := fs.Duration("observe", 0, "How long to watch live edits to find contested areas")
+	heatWeight := fs.Float64("heat", 5, "Points of cost an observed edit in the area is worth")
+	step := fs.Int("step", 4, "Coarse search step in pixels")
+	top := fs.Int("top", 10, "How many placements to recommend")
+	palette := fs.String("palette", "", "Palette of the canvas, see patriot -palette")
+	fs.Parse(args)
+	usePalette(*palette)
+
+	if *resourcePath == "" {
+		log.Infof("Missing -resource")
+		fs.Usage()
+		os.Exit(2)
+	}
+	res, err := quantize.Load(*resourcePath, *mode)
+	if err != nil {
+		log.Infof("Failed to load resource: %v", err)
+		os.Exit(1)
+	}
+
+	img := &art.Image{}
+	var heat *place.Heat
+	if *keyframe != "" {
+		data, err := ioutil.ReadFile(*keyframe)
+		if err == nil {
+			err = img.ParseKeyframe(1, data, false)
+		}
+		if err != nil {
+			log.Infof("Failed to load keyframe: %v", err)
+			os.Exit(1)
+		}
+	} else {
+		canvas, err := sp.New(*backend, *url)
+		if err != nil {
+			log.Infof("Failed to set up canvas: %v", err)
+			os.Exit(1)
+		}
+		work.UpdateImage(img, canvas)
+		if *observe > 0 {
+			heat = place.NewHeat()
+			img.AddListener(heat)
+			observeEdits(img, canvas, *observe)
+			log.Infof("Observed %v edits", heat.Total())
+		}
+	}
+
+	w, h := res.Dimensions()
+	log.Infof("Searching placements for %vx%v %v ..", w, h, *resourcePath)
+	for i, p := range place.Find(img, res, heat, *heatWeight, *step, *top) {
+		log.Infof("#%v at %v,%v: %v/%v matching, %v over White, %v points to claim, %v edits seen, score %.0f",
+			i+1, p.X, p.Y, p.Matching, p.Pixels, p.White, p.Cost, p.Heat, p.Score)
+	}
+}
+
+// Applies live edits to img for d, subscribing again whenever the connection ends early
+func observeEdits(img *art.Image, canvas sp.Canvas, d time.Duration) {
+	log.Infof("Observing edits for %v ..", d)
+	deadline := time.Now().Add(d)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		wg := &sync.WaitGroup{}
+		for {
+			wg.Add(1)
+			result := realtime.Realtime(wg, img, canvas)
+			shutdown.ShutdownLock.RLock()
+			if shutdown.Shutdown {
+				shutdown.ShutdownLock.RUnlock()
+				return
+			}
+			shutdown.ShutdownLock.RUnlock()
+			log.Infof("Observation interrupted with %v left, subscribing again", time.Until(deadline).Truncate(time.Second))
+			if result == realtime.Reload {
+				work.UpdateImage(img, canvas)
+			}
+		}
+	}()
+
+	time.Sleep(time.Until(deadline))
+	// Realtime only gives up on dialing once shutting down
+	shutdown.ShutdownLock.Lock()
+	shutdown.Shutdown = true
+	shutdown.ShutdownLock.Unlock()
+	for {
+		// Realtime may have subscribed again after an earlier Shutdown
+		realtime.Shutdown()
+		select {
+		case <-stopped:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package place finds spots on the canvas where art is cheap to claim and unlikely to be fought over
+package place
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/painter"
+)
+
+type Placement struct {
+	X        int
+	Y        int
+	Pixels   int // Pixels the art has
+	Matching int // Pixels already the right color
+	White    int // Pixels to paint over White, the cheap ones
+	Cost     int // Points to draw the rest of the art
+	Heat     int // Edits seen in the area while observing
+	Score    float64
+}
+
+// Heat counts realtime edits per pixel, attach it to an image with AddListener
+type Heat struct {
+	lock   sync.Mutex
+	counts []int
+}
+
+func NewHeat() *Heat {
+	return &Heat{counts: make([]int, art.CanvasWidth*art.CanvasHeight)}
+}
+
+func (h *Heat) PixelChanged(x, y, c, version int) {
+	if x < 0 || x >= art.CanvasWidth || y < 0 || y >= art.CanvasHeight {
+		return
+	}
+	h.lock.Lock()
+	h.counts[x+y*art.CanvasWidth]++
+	h.lock.Unlock()
+}
+
+func (h *Heat) KeyframeChanged(image *art.Image) {}
+
+// Returns the total number of edits counted
+func (h *Heat) Total() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	total := 0
+	for _, c := range h.counts {
+		total += c
+	}
+	return total
+}
+
+// Summed-area table of the heat, with an extra zero row and column
+func (h *Heat) table() []int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	w := art.CanvasWidth + 1
+	sat := make([]int, w*(art.CanvasHeight+1))
+	for y := 0; y < art.CanvasHeight; y++ {
+		for x := 0; x < art.CanvasWidth; x++ {
+			sat[(x+1)+(y+1)*w] = h.counts[x+y*art.CanvasWidth] + sat[x+(y+1)*w] + sat[(x+1)+y*w] - sat[x+y*w]
+		}
+	}
+	return sat
+}
+
+type pixel struct {
+	dx, dy, c int
+}
+
+// Scores every placement of res on the canvas and returns the best top ones that don't overlap much.
+// Candidates are first scored every step pixels and then refined around the best ones.
+// The score is the cost to claim the spot plus heatWeight points per edit seen in it, lower is better.
+func Find(canvas, res *art.Image, heat *Heat, heatWeight float64, step, top int) []Placement {
+	cw, ch, colors := canvas.Colors()
+	rw, rh := res.Dimensions()
+	if rw > cw || rh > ch || top <= 0 {
+		return nil
+	}
+	if step < 1 {
+		step = 1
+	}
+	var pixels []pixel
+	for dy := 0; dy < rh; dy++ {
+		for dx := 0; dx < rw; dx++ {
+			if c := res.At(dx, dy); c >= 0 && c != art.Transparent {
+				pixels = append(pixels, pixel{dx, dy, c})
+			}
+		}
+	}
+	var sat []int
+	if heat != nil {
+		sat = heat.table()
+	}
+
+	score := func(x, y int) Placement {
+		p := Placement{X: x, Y: y, Pixels: len(pixels)}
+		for _, px := range pixels {
+			c := colors[(x+px.dx)+(y+px.dy)*cw]
+			if c == px.c {
+				p.Matching++
+				continue
+			}
+			if c == art.White {
+				p.White++
+			}
+			p.Cost += painter.DrawCallCost(c)
+		}
+		if sat != nil {
+			w := art.CanvasWidth + 1
+			p.Heat = sat[(x+rw)+(y+rh)*w] - sat[x+(y+rh)*w] - sat[(x+rw)+y*w] + sat[x+y*w]
+		}
+		p.Score = float64(p.Cost) + heatWeight*float64(p.Heat)
+		return p
+	}
+
+	// Coarse pass
+	var coarse []Placement
+	for y := 0; y <= ch-rh; y += step {
+		for x := 0; x <= cw-rw; x += step {
+			coarse = append(coarse, score(x, y))
+		}
+	}
+	sortPlacements(coarse)
+
+	// Refine around the best distinct coarse candidates
+	var refined []Placement
+	for _, c := range distinct(coarse, rw, rh, 2*top) {
+		best := c
+		for y := c.Y - step + 1; y < c.Y+step; y++ {
+			for x := c.X - step + 1; x < c.X+step; x++ {
+				if x < 0 || y < 0 || x > cw-rw || y > ch-rh {
+					continue
+				}
+				if p := score(x, y); p.Score < best.Score {
+					best = p
+				}
+			}
+		}
+		refined = append(refined, best)
+	}
+	sortPlacements(refined)
+	return distinct(refined, rw, rh, top)
+}
+
+// Returns up to n of the sorted placements, skipping those overlapping a better one by more than half
+func distinct(ps []Placement, w, h, n int) []Placement {
+	var best []Placement
+	for _, p := range ps {
+		overlaps := false
+		for _, b := range best {
+			ox := w - abs(p.X-b.X)
+			oy := h - abs(p.Y-b.Y)
+			if ox > 0 && oy > 0 && 2*ox*oy > w*h {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			best = append(best, p)
+			if len(best) == n {
+				break
+			}
+		}
+	}
+	return best
+}
+
+func sortPlacements(ps []Placement) {
+	sort.SliceStable(ps, func(i, j int) bool {
+		if ps[i].Score != ps[j].Score {
+			return ps[i].Score < ps[j].Score
+		}
+		return ps[i].Matching > ps[j].Matching
+	})
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
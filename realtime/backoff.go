@@ -0,0 +1,54 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package realtime
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff spaces out retries with exponentially growing delays and jitter
+type Backoff struct {
+	Base     time.Duration // Delay before the second attempt
+	Max      time.Duration // Delays never grow past this
+	attempts int
+}
+
+func NewBackoff(base, max time.Duration) *Backoff {
+	return &Backoff{Base: base, Max: max}
+}
+
+// Counts an attempt and returns how long to wait before making it, the first attempt doesn't wait
+func (b *Backoff) Next() time.Duration {
+	b.attempts++
+	if b.attempts == 1 {
+		return 0
+	}
+	d := b.Max
+	if shift := uint(b.attempts - 2); shift < 32 && b.Base<<shift < b.Max {
+		d = b.Base << shift
+	}
+	// Half of the delay is random so that clients don't all retry at once
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Returns the number of attempts since the last reset
+func (b *Backoff) Attempts() int {
+	return b.attempts
+}
+
+func (b *Backoff) Reset() {
+	b.attempts = 0
+}
@@ -15,81 +15,209 @@
 package realtime
 
 import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
-	"net/url"
+	"expvar"
 	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
-
 	"github.com/xStrom/patriot/art"
 	"github.com/xStrom/patriot/log"
+	"github.com/xStrom/patriot/sp"
+	"github.com/xStrom/patriot/sp/protocol"
+	"github.com/xStrom/patriot/work/shutdown"
+)
+
+// Reconnect policy
+var (
+	BackoffBase     = time.Second      // Delay before the second dial
+	BackoffMax      = 2 * time.Minute  // Dial delays never grow past this
+	CircuitFailures = 5                // Failed dials in a row after which the canvas gets polled instead
+	PollInterval    = 15 * time.Second // How often the canvas gets polled while the websocket is unavailable
+)
+
+// Why Realtime returned
+type Result int
+
+const (
+	Disconnected Result = iota // The connection dropped, resuming from the image version is enough
+	Gap                        // Edits went missing, resuming from the image version replays them
+	Reload                     // The server can't replay the missing edits, a new keyframe is needed
 )
 
-var c *websocket.Conn
+// Gaps in a row after which a new keyframe gets fetched instead of resuming
+const maxGaps = 3
+
+var c sp.Subscription
 var done chan struct{}
 
-func Realtime(wg *sync.WaitGroup, image *art.Image) {
+// Gaps since the last batch of edits that followed the previous one
+var gaps int
+
+// Kept across connections so that a server that keeps dropping us gets backed off from too
+var reconnect = NewBackoff(BackoffBase, BackoffMax)
+
+// Whether the websocket is unavailable and the canvas gets polled instead
+var circuitOpen bool
+var lastPoll time.Time
+
+var metrics = expvar.NewMap("realtime")
+
+// Applies edits from the websocket to the image until the connection ends and returns why it did
+func Realtime(wg *sync.WaitGroup, image *art.Image, canvas sp.Canvas) Result {
 	done = make(chan struct{})
-	u := url.URL{Scheme: "wss", Host: "josephg.com", Path: "/sp/ws", RawQuery: fmt.Sprintf("from=%v", image.Version())}
+	reconnect.Base, reconnect.Max = BackoffBase, BackoffMax
 
-connect:
-	log.Infof("connecting to %s", u.String())
-	var err error
-	c, _, err = websocket.DefaultDialer.Dial(u.String(), nil)
-	if err != nil {
+	for {
+		if delay := reconnect.Next(); delay > 0 {
+			log.Infof("Reconnecting in %v ..", delay)
+			metrics.Add("reconnectWaitMs", int64(delay/time.Millisecond))
+			if !wait(delay, image, canvas) {
+				log.Infof("Shutting down realtime")
+				close(done)
+				wg.Done()
+				return Disconnected
+			}
+		}
+		var err error
+		metrics.Add("dials", 1)
+		c, err = canvas.Subscribe(image.Version())
+		if err == nil {
+			break
+		}
+		metrics.Add("dialFailures", 1)
 		log.Infof("dial err: %v", err)
-		goto connect
+		if !circuitOpen && reconnect.Attempts() >= CircuitFailures {
+			log.Infof("Websocket unavailable after %v attempts, polling the canvas every %v until it's back", reconnect.Attempts(), PollInterval)
+			setCircuit(true)
+		}
+	}
+	if circuitOpen {
+		log.Infof("Websocket is back, stopped polling")
+		setCircuit(false)
 	}
+	metrics.Add("connected", 1)
 
+	// Version of the last edit applied, edits arrive in batches that should continue from it
+	last := image.Version()
+	// Whether no batch has arrived since subscribing
+	resumed := true
+	result := Disconnected
+read:
 	for {
-		_, message, err := c.ReadMessage()
+		message, err := c.ReadMessage()
 		if err != nil {
 			log.Infof("read error: %v", err)
 			break
 		}
-		if bytes.Compare(message, []byte("reload")) == 0 {
-			log.Infof("Got reload command")
-			break
+		// The connection works, so the next one doesn't need to wait
+		reconnect.Reset()
+		metrics.Add("messages", 1)
+		msg, err := protocol.Decode(message, protocol.Lenient)
+		if err != nil {
+			log.Infof("recv unknown: %v", err)
+			continue
 		}
-		if bytes.Compare(message, []byte("refresh")) == 0 {
+		switch m := msg.(type) {
+		case protocol.Reload:
+			log.Infof("Got reload command")
+			result = Reload
+			break read
+		case protocol.Refresh:
 			log.Infof("Got refresh command")
-			break
-		}
-		if len(message) >= 7 {
-			version := int(binary.LittleEndian.Uint32(message[0:4]))
-			for i := 4; i < len(message); i += 3 {
-				if len(message) >= i+3 {
-					x, y, color := decodeEdit(message[i : i+3])
-					image.UpdatePixel(x, y, color, version)
-				} else {
-					log.Infof("recv unknown suffix on edits: %v", message[i:])
+			result = Reload
+			break read
+		case *protocol.Edits:
+			if resumed && m.Version < last {
+				// E.g. the server restarted, its edits will never get past the version of the image
+				log.Infof("Got edits up to v%v after resuming from v%v, fetching a new keyframe", m.Version, last)
+				result = Reload
+				break read
+			}
+			resumed = false
+			// Edits of a batch have consecutive versions, see protocol.Edits.First
+			if m.First() > last+1 {
+				gaps++
+				metrics.Add("gaps", 1)
+				log.Infof("Missed edits v%v to v%v, resuming from v%v", last+1, m.First()-1, last)
+				result = Gap
+				if gaps >= maxGaps {
+					log.Infof("Missed edits %v times in a row, fetching a new keyframe", gaps)
+					result = Reload
+				}
+				break read
+			}
+			gaps = 0
+			for i, e := range m.Edits {
+				// Edits already in the image, e.g. from the keyframe, are skipped
+				if v := m.First() + i; v > last {
+					image.UpdatePixel(e.X, e.Y, e.Color, v)
+					metrics.Add("edits", 1)
 				}
 			}
-		} else {
-			log.Infof("recv unknown: %v", message)
+			if len(m.Trailing) > 0 {
+				log.Infof("recv unknown suffix on edits: %v", m.Trailing)
+			}
+			if m.Version > last {
+				last = m.Version
+			}
 		}
 	}
 
 	log.Infof("Close in Realtime")
+	metrics.Add("connected", -1)
 	c.Close()
 	close(done)
 	wg.Done()
+	return result
+}
+
+func setCircuit(open bool) {
+	circuitOpen = open
+	v := new(expvar.Int)
+	if open {
+		v.Set(1)
+	}
+	metrics.Set("circuitOpen", v)
 }
 
-// returns x, y, color.
-func decodeEdit(data []byte) (int, int, int) {
-	xx := uint(data[0])
-	yx := uint(data[1])
-	cy := uint(data[2])
+// Sleeps for d, polling the canvas meanwhile while the circuit is open. Returns false when shutting down.
+func wait(d time.Duration, image *art.Image, canvas sp.Canvas) bool {
+	deadline := time.Now().Add(d)
+	for {
+		shutdown.ShutdownLock.RLock()
+		if shutdown.Shutdown {
+			shutdown.ShutdownLock.RUnlock()
+			return false
+		}
+		shutdown.ShutdownLock.RUnlock()
 
-	x := xx | ((yx & 0x3) << 8)
-	y := (yx >> 2) | ((cy & 0xf) << 6)
-	color := cy >> 4
+		if circuitOpen && time.Since(lastPoll) >= PollInterval {
+			poll(image, canvas)
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			return true
+		}
+		if left > 500*time.Millisecond {
+			left = 500 * time.Millisecond
+		}
+		time.Sleep(left)
+	}
+}
 
-	return int(x), int(y), int(color)
+// Fetches the whole canvas in place of the edits the websocket would have sent
+func poll(image *art.Image, canvas sp.Canvas) {
+	lastPoll = time.Now()
+	metrics.Add("polls", 1)
+	data, version, err := canvas.FetchImage()
+	if err == nil {
+		err = image.ParseKeyframe(version, data, false)
+	}
+	if err != nil {
+		metrics.Add("pollFailures", 1)
+		log.Infof("Failed to poll the canvas: %v", err)
+		return
+	}
+	log.Infof("Polled the canvas at v%v", version)
 }
 
 // TODO: Currently c isn't set to nil (need sync for that anyway), so Shutdown could be called for old closed connection
@@ -97,9 +225,7 @@ func Shutdown() {
 	if c == nil {
 		return
 	}
-	// To cleanly close a connection, a client should send a close
-	// frame and wait for the server to close the connection.
-	err := c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	err := c.Shutdown()
 	if err != nil {
 		log.Infof("write close error: %v", err)
 		return
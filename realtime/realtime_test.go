@@ -0,0 +1,135 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package realtime
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/sp/protocol"
+	"github.com/xStrom/patriot/sp/sptest"
+)
+
+// Runs Realtime until it returns, calling connected once it has subscribed
+func runRealtime(t *testing.T, image *art.Image, canvas *sptest.Canvas, connected func()) Result {
+	t.Helper()
+	subscribed := len(canvas.Subscribed())
+	results := make(chan Result, 1)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		results <- Realtime(wg, image, canvas)
+	}()
+	waitFor(t, func() bool { return len(canvas.Subscribed()) > subscribed })
+	connected()
+	select {
+	case result := <-results:
+		wg.Wait()
+		return result
+	case <-time.After(10 * time.Second):
+		t.Fatal("Realtime didn't return")
+	}
+	return Disconnected
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Sends a batch of a single edit that skips versions
+func sendGap(t *testing.T, canvas *sptest.Canvas, version int) {
+	t.Helper()
+	message, err := protocol.Encode(&protocol.Edits{Version: version, Edits: []protocol.Edit{{X: 1, Y: 1, Color: art.Red}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	canvas.Send(message)
+}
+
+func TestGaps(t *testing.T) {
+	canvas := sptest.NewCanvas()
+	data, version, err := canvas.FetchImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := &art.Image{}
+	if err := image.ParseKeyframe(version, data, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// An edit that follows the keyframe gets applied, the batch after it misses some
+	result := runRealtime(t, image, canvas, func() {
+		canvas.Edit(0, 0, art.Black)
+		waitFor(t, func() bool { return image.Version() == canvas.Version() })
+		sendGap(t, canvas, canvas.Version()+5)
+	})
+	last := canvas.Version()
+	if result != Gap {
+		t.Fatalf("Got %v after a gap, want Gap", result)
+	}
+	if image.Version() != last || image.At(1, 1) != art.White {
+		t.Fatalf("Applied the edits after the gap")
+	}
+
+	// Every reconnect resumes from the last applied edit, until there have been too many gaps in a row
+	for i := 1; i < maxGaps; i++ {
+		want := Gap
+		if i == maxGaps-1 {
+			want = Reload
+		}
+		result := runRealtime(t, image, canvas, func() {
+			sendGap(t, canvas, last+5)
+		})
+		subscribed := canvas.Subscribed()
+		if from := subscribed[len(subscribed)-1]; from != last {
+			t.Errorf("Resumed from v%v, want v%v", from, last)
+		}
+		if result != want {
+			t.Errorf("Got %v after %v gaps in a row, want %v", result, i+1, want)
+		}
+	}
+}
+
+func TestResumeAfterServerRestart(t *testing.T) {
+	canvas := sptest.NewCanvas()
+	data, _, err := canvas.FetchImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The image is from before the restart, when the canvas was further along
+	image := &art.Image{}
+	if err := image.ParseKeyframe(50, data, false); err != nil {
+		t.Fatal(err)
+	}
+
+	result := runRealtime(t, image, canvas, func() {
+		canvas.Edit(1, 1, art.Red)
+	})
+	if result != Reload {
+		t.Errorf("Got %v after resuming from a version the canvas doesn't have, want Reload", result)
+	}
+	if image.At(1, 1) != art.White {
+		t.Error("Applied an edit from after the restart")
+	}
+}
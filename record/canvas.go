@@ -0,0 +1,208 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package record
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/xStrom/patriot/log"
+	"github.com/xStrom/patriot/sp"
+	"github.com/xStrom/patriot/sp/protocol"
+)
+
+// Recorder is a canvas that logs every keyframe and websocket message of the canvas it wraps
+type Recorder struct {
+	canvas sp.Canvas
+	w      *Writer
+}
+
+func NewRecorder(canvas sp.Canvas, w *Writer) *Recorder {
+	return &Recorder{canvas, w}
+}
+
+func (r *Recorder) FetchImage() ([]byte, int, error) {
+	data, version, err := r.canvas.FetchImage()
+	if err == nil {
+		if err := r.w.WriteKeyframe(time.Now(), version, data); err != nil {
+			log.Infof("%v", err)
+		}
+	}
+	return data, version, err
+}
+
+func (r *Recorder) DrawPixel(x, y, c int) (error, int) {
+	return r.canvas.DrawPixel(x, y, c)
+}
+
+func (r *Recorder) Subscribe(from int) (sp.Subscription, error) {
+	s, err := r.canvas.Subscribe(from)
+	if err != nil {
+		return nil, err
+	}
+	return &recordedSubscription{s, r.w}, nil
+}
+
+type recordedSubscription struct {
+	sp.Subscription
+	w *Writer
+}
+
+func (s *recordedSubscription) ReadMessage() ([]byte, error) {
+	message, err := s.Subscription.ReadMessage()
+	if err == nil {
+		if err := s.w.WriteMessage(time.Now(), message); err != nil {
+			log.Infof("%v", err)
+		}
+	}
+	return message, err
+}
+
+// Replay is a canvas that plays back a log instead of talking to a server.
+// Messages arrive with the recorded delays divided by the speed, a keyframe in the log is announced with a reload.
+// Drawing does nothing, the canvas only ever changes the way it did when it was recorded.
+type Replay struct {
+	r     *Reader
+	speed float64
+
+	lock     sync.Mutex
+	keyframe *Record // The keyframe FetchImage returns
+	next     *Record // Read ahead, nil at the end of the log
+	played   time.Time
+	messages int
+}
+
+// Opens the log at path for replay, a speed of 0 replays as fast as possible
+func NewReplay(path string, speed float64) (*Replay, error) {
+	r, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Replay{r: r, speed: speed}
+	// Whatever came before the first keyframe has nothing to apply to
+	for {
+		p.advance()
+		if p.next == nil {
+			r.Close()
+			return nil, errors.Errorf("%v has no keyframe", path)
+		}
+		if p.next.Kind == Keyframe {
+			break
+		}
+	}
+	return p, nil
+}
+
+// Reads the next record into p.next, must hold the lock unless nothing else uses the replay yet
+func (p *Replay) advance() {
+	rec, err := p.r.Next()
+	if err != nil {
+		if err != io.EOF {
+			log.Infof("Failed to read record: %v", err)
+		}
+		rec = nil
+	}
+	p.next = rec
+}
+
+// Takes the next record, must hold the lock
+func (p *Replay) take() *Record {
+	rec := p.next
+	p.played = rec.Time
+	p.advance()
+	return rec
+}
+
+// Returns how long to wait until the next record is due, must hold the lock
+func (p *Replay) delay() time.Duration {
+	if p.played.IsZero() || p.speed <= 0 {
+		return 0
+	}
+	return time.Duration(float64(p.next.Time.Sub(p.played)) / p.speed)
+}
+
+// Returns the recorded keyframe, moving on to the next keyframe of the log if that's what comes next
+func (p *Replay) FetchImage() ([]byte, int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.next != nil && p.next.Kind == Keyframe {
+		p.keyframe = p.take()
+		log.Infof("Replaying from the keyframe recorded at %v", p.keyframe.Time.Format(time.RFC3339))
+	}
+	return p.keyframe.Data, p.keyframe.Version, nil
+}
+
+func (p *Replay) DrawPixel(x, y, c int) (error, int) {
+	return nil, 200
+}
+
+func (p *Replay) Subscribe(from int) (sp.Subscription, error) {
+	return &replaySubscription{p: p, closed: make(chan struct{})}, nil
+}
+
+type replaySubscription struct {
+	p         *Replay
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (s *replaySubscription) ReadMessage() ([]byte, error) {
+	p := s.p
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for {
+		if p.next == nil {
+			p.lock.Unlock()
+			log.Infof("Replay finished after %v messages", p.messages)
+			<-s.closed
+			p.lock.Lock()
+			return nil, errors.New("Replay subscription closed")
+		}
+		if p.next.Kind == Keyframe {
+			// The recording fetched a keyframe here, so have realtime do the same
+			return protocol.Encode(protocol.Reload{})
+		}
+		rec, delay := p.next, p.delay()
+		if delay > 0 {
+			// FetchImage mustn't have to wait for the delay, e.g. when polling
+			p.lock.Unlock()
+			select {
+			case <-time.After(delay):
+			case <-s.closed:
+				p.lock.Lock()
+				return nil, errors.New("Replay subscription closed")
+			}
+			p.lock.Lock()
+			if p.next != rec {
+				continue
+			}
+		}
+		p.take()
+		p.messages++
+		return rec.Data, nil
+	}
+}
+
+func (s *replaySubscription) Shutdown() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
+
+func (s *replaySubscription) Close() error {
+	return s.Shutdown()
+}
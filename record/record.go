@@ -0,0 +1,192 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package record keeps an append-only log of what the canvas sent, to be replayed later
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/xStrom/patriot/log"
+)
+
+// Kinds of records
+const (
+	Keyframe = 'K' // A keyframe png and its version
+	Message  = 'M' // A raw websocket message
+)
+
+// Starts every log file
+const magic = "patriot-record-1\n"
+
+// Kind, time in Unix nanoseconds, version and data length
+const headerSize = 1 + 8 + 4 + 4
+
+// Larger records can only come from a corrupt log
+const maxDataSize = 64 << 20
+
+type Record struct {
+	Kind    byte
+	Time    time.Time
+	Version int // Only for keyframes
+	Data    []byte
+}
+
+// Writer appends records to a log file
+type Writer struct {
+	lock sync.Mutex
+	f    *os.File
+}
+
+// Opens the log at path for appending, creating it if needed.
+// A record cut off at the end of the log, e.g. by a crash, gets truncated so that new records follow the last whole one.
+func Create(path string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to open record")
+	}
+	fi, err := f.Stat()
+	if err == nil && fi.Size() == 0 {
+		_, err = f.Write([]byte(magic))
+	} else if err == nil {
+		err = truncateTail(f, fi.Size())
+	}
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "Failed to start record %v", path)
+	}
+	return &Writer{f: f}, nil
+}
+
+// Cuts the log after its last whole record and moves to the end
+func truncateTail(f *os.File, size int64) error {
+	r := bufio.NewReader(f)
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, head); err != nil || string(head) != magic {
+		return errors.New("Not a record")
+	}
+	end := int64(len(magic))
+	for {
+		head := make([]byte, headerSize)
+		if _, err := io.ReadFull(r, head); err != nil {
+			break
+		}
+		n := binary.LittleEndian.Uint32(head[13:])
+		if head[0] != Keyframe && head[0] != Message || n > maxDataSize {
+			break
+		}
+		if _, err := r.Discard(int(n)); err != nil {
+			break
+		}
+		end += headerSize + int64(n)
+	}
+	if end < size {
+		log.Infof("Truncating %v bytes after the last whole record", size-end)
+		if err := f.Truncate(end); err != nil {
+			return errors.Wrap(err, "Failed to truncate")
+		}
+	}
+	_, err := f.Seek(end, io.SeekStart)
+	return err
+}
+
+func (w *Writer) WriteKeyframe(t time.Time, version int, png []byte) error {
+	return w.write(&Record{Keyframe, t, version, png})
+}
+
+func (w *Writer) WriteMessage(t time.Time, message []byte) error {
+	return w.write(&Record{Message, t, 0, message})
+}
+
+func (w *Writer) write(r *Record) error {
+	// A record is written with a single write so that a crash can only cut off the last one, which Create truncates
+	buf := make([]byte, headerSize, headerSize+len(r.Data))
+	buf[0] = r.Kind
+	binary.LittleEndian.PutUint64(buf[1:], uint64(r.Time.UnixNano()))
+	binary.LittleEndian.PutUint32(buf[9:], uint32(r.Version))
+	binary.LittleEndian.PutUint32(buf[13:], uint32(len(r.Data)))
+	buf = append(buf, r.Data...)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if _, err := w.f.Write(buf); err != nil {
+		return errors.Wrap(err, "Failed to write record")
+	}
+	return nil
+}
+
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.f.Close()
+}
+
+// Reader reads records from a log file in the order they were written
+type Reader struct {
+	f *os.File
+	r *bufio.Reader
+}
+
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to open record")
+	}
+	r := bufio.NewReader(f)
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, head); err != nil || string(head) != magic {
+		f.Close()
+		return nil, errors.Errorf("%v isn't a record", path)
+	}
+	return &Reader{f, r}, nil
+}
+
+// Returns the next record, io.EOF at the end of the log.
+// A record cut off by a crash is treated as the end.
+func (r *Reader) Next() (*Record, error) {
+	head := make([]byte, headerSize)
+	if _, err := io.ReadFull(r.r, head); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(head[13:])
+	if head[0] != Keyframe && head[0] != Message || size > maxDataSize {
+		return nil, errors.Errorf("Corrupt record header %v", head)
+	}
+	rec := &Record{
+		Kind:    head[0],
+		Time:    time.Unix(0, int64(binary.LittleEndian.Uint64(head[1:]))),
+		Version: int(binary.LittleEndian.Uint32(head[9:])),
+		Data:    make([]byte, size),
+	}
+	if _, err := io.ReadFull(r.r, rec.Data); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
@@ -0,0 +1,252 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package record
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/config"
+	"github.com/xStrom/patriot/realtime"
+	"github.com/xStrom/patriot/sp/protocol"
+	"github.com/xStrom/patriot/work"
+	"github.com/xStrom/patriot/work/shutdown"
+)
+
+func TestCreateTruncatesCutOffRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "patriot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.rec")
+
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, m := range []string{"first", "cut off"} {
+		if err := w.WriteMessage(now, []byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Like a crash in the middle of writing the second record
+	if err := os.Truncate(path, fi.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	if w, err = Create(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMessage(now, []byte("after the crash")); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var got []string
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(rec.Data))
+	}
+	if len(got) != 2 || got[0] != "first" || got[1] != "after the crash" {
+		t.Errorf("Got records %q", got)
+	}
+}
+
+// Encodes a blank canvas with the pixels as a keyframe
+func keyframe(t *testing.T, pixels map[[2]int]int) []byte {
+	t.Helper()
+	img := image.NewPaletted(image.Rect(0, 0, art.CanvasWidth, art.CanvasHeight), art.ColorPalette())
+	for p, c := range pixels {
+		img.SetColorIndex(p[0], p[1], uint8(c))
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encode(t *testing.T, m protocol.Message) []byte {
+	t.Helper()
+	data, err := protocol.Encode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Writes a log to dir of a second apart: an edit from before the first keyframe at v10, edits up to v13,
+// a reload, a keyframe at v20 and a batch of edits up to v22
+func writeReplayLog(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "replay.rec")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	now := time.Date(2017, 2, 24, 12, 0, 0, 0, time.UTC)
+	next := func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	edits := func(version int, edits ...protocol.Edit) []byte {
+		return encode(t, &protocol.Edits{Version: version, Edits: edits})
+	}
+	for _, err := range []error{
+		w.WriteMessage(next(), edits(9, protocol.Edit{X: 9, Y: 9, Color: art.Red})),
+		w.WriteKeyframe(next(), 10, keyframe(t, map[[2]int]int{{1, 1}: art.Black})),
+		w.WriteMessage(next(), edits(11, protocol.Edit{X: 1, Y: 1, Color: art.Red})),
+		w.WriteMessage(next(), edits(13, protocol.Edit{X: 2, Y: 2, Color: art.Black}, protocol.Edit{X: 3, Y: 3, Color: art.DarkBlue})),
+		w.WriteMessage(next(), encode(t, protocol.Reload{})),
+		w.WriteKeyframe(next(), 20, keyframe(t, map[[2]int]int{{1, 1}: art.Red, {2, 2}: art.Black, {3, 3}: art.DarkBlue, {5, 5}: art.Green})),
+		w.WriteMessage(next(), edits(22, protocol.Edit{X: 2, Y: 2, Color: art.White}, protocol.Edit{X: 6, Y: 6, Color: art.Yellow})),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "patriot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p, err := NewReplay(writeReplayLog(t, dir), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfgPath := filepath.Join(dir, "resources.json")
+	if err := ioutil.WriteFile(cfgPath, []byte(`{"resources": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := &art.Image{}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go work.Work(wg, img, p, cfg)
+	deadline := time.Now().Add(10 * time.Second)
+	for img.Version() != 22 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	shutdown.ShutdownLock.Lock()
+	shutdown.Shutdown = true
+	shutdown.ShutdownLock.Unlock()
+	defer func() {
+		shutdown.ShutdownLock.Lock()
+		shutdown.Shutdown = false
+		shutdown.ShutdownLock.Unlock()
+	}()
+	realtime.Shutdown()
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(15 * time.Second):
+		t.Fatal("Work didn't shut down")
+	}
+
+	if v := img.Version(); v != 22 {
+		t.Fatalf("Replayed up to v%v, want v22", v)
+	}
+	want := map[[2]int]int{
+		{1, 1}: art.Red, {2, 2}: art.White, {3, 3}: art.DarkBlue, {5, 5}: art.Green, {6, 6}: art.Yellow,
+		{9, 9}: art.White, // Only edited before the first keyframe
+	}
+	for p, c := range want {
+		if got := img.At(p[0], p[1]); got != c {
+			t.Errorf("%v:%v is %v, want %v", p[0], p[1], got, c)
+		}
+	}
+}
+
+func TestReplayFetchesWhileWaiting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "patriot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A second of the recording takes 1000 seconds
+	p, err := NewReplay(writeReplayLog(t, dir), 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p.FetchImage(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := p.Subscribe(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := make(chan error, 1)
+	go func() {
+		_, err := s.ReadMessage()
+		read <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	fetched := make(chan struct{})
+	go func() {
+		p.FetchImage()
+		close(fetched)
+	}()
+	select {
+	case <-fetched:
+	case <-time.After(5 * time.Second):
+		t.Fatal("FetchImage waited for the next message")
+	}
+	s.Close()
+	if err := <-read; err == nil {
+		t.Error("ReadMessage returned a message after Close")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xStrom/patriot/log"
+	"github.com/xStrom/patriot/sim"
+)
+
+type specList []string
+
+func (l *specList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *specList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func simCommand(args []string) {
+	var vandalSpecs, watchSpecs specList
+	fs := flag.NewFlagSet("sim", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8000", "Address to listen on")
+	keyframe := fs.String("keyframe", "", "PNG to start the canvas from instead of a blank one")
+	palette := fs.String("palette", "", "Palette of the canvas, see patriot -palette")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "Random seed for vandals")
+	fs.Var(&vandalSpecs, "vandal", "Vandal to run, e.g. eraser:resource=data/estville2.png,x=735,y=875,rate=2 (repeatable)")
+	fs.Var(&watchSpecs, "watch", "Art to measure damage and restore times of, e.g. data/estville2.png@735,875 (repeatable)")
+	fs.Parse(args)
+	usePalette(*palette)
+
+	s := sim.New()
+	if *keyframe != "" {
+		data, err := ioutil.ReadFile(*keyframe)
+		if err != nil {
+			log.Infof("Failed to read keyframe: %v", err)
+			os.Exit(1)
+		}
+		if err := s.LoadKeyframe(data); err != nil {
+			log.Infof("Failed to load keyframe: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	stop := make(chan struct{})
+	done := &sync.WaitGroup{}
+	for i, spec := range vandalSpecs {
+		v, rate, err := sim.ParseVandal(spec)
+		if err != nil {
+			log.Infof("Failed to set up vandal: %v", err)
+			os.Exit(1)
+		}
+		log.Infof("Launching vandal %v at %v edits/s", v.Name(), rate)
+		done.Add(1)
+		go func(v sim.Vandal, rate float64, seed int64) {
+			s.RunVandal(v, rate, seed, stop)
+			done.Done()
+		}(v, rate, *seed+int64(i))
+	}
+	watches := make([]*sim.Watch, 0, len(watchSpecs))
+	for _, spec := range watchSpecs {
+		w, err := sim.ParseWatch(spec)
+		if err != nil {
+			log.Infof("Failed to set up watch: %v", err)
+			os.Exit(1)
+		}
+		watches = append(watches, w)
+	}
+	if len(watches) > 0 {
+		done.Add(1)
+		go func() {
+			s.RunWatches(watches, stop)
+			done.Done()
+		}()
+	}
+
+	server := &http.Server{Addr: *addr, Handler: s}
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+		log.Infof("interrupt -- shutting down simulator ..")
+		close(stop)
+		done.Wait()
+		server.Shutdown(context.Background())
+	}()
+
+	log.Infof("Simulator listening on http://%v/sp", *addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Infof("Simulator failed: %v", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sim
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/art/resource"
+	"github.com/xStrom/patriot/log"
+)
+
+const reportInterval = 10 * time.Second
+
+// Watch measures how damaged an art piece on the canvas is and how fast it gets restored
+type Watch struct {
+	path string
+	x    int
+	y    int
+	img  *art.Image
+
+	damaged     time.Time // When the current damage started, zero if intact
+	restores    int
+	restoreTime time.Duration
+	maxDamage   int
+	samples     int
+	damageSum   int
+}
+
+// Parses a watch spec of the form path@x,y, e.g. data/estville2.png@735,875
+func ParseWatch(spec string) (*Watch, error) {
+	i := strings.LastIndex(spec, "@")
+	if i < 0 {
+		return nil, errors.Errorf("Invalid watch %q, expected path@x,y", spec)
+	}
+	coords := strings.Split(spec[i+1:], ",")
+	if len(coords) != 2 {
+		return nil, errors.Errorf("Invalid watch %q, expected path@x,y", spec)
+	}
+	x, errX := strconv.Atoi(coords[0])
+	y, errY := strconv.Atoi(coords[1])
+	if errX != nil || errY != nil {
+		return nil, errors.Errorf("Invalid watch coordinates in %q", spec)
+	}
+	img, err := resource.LoadImage(spec[:i])
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to load watch %q", spec)
+	}
+	return &Watch{path: spec[:i], x: x, y: y, img: img}, nil
+}
+
+// Returns the number of pixels that don't match the art and the number of pixels the art has
+func (w *Watch) damage(s *Server) (int, int) {
+	width, height := w.img.Dimensions()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	broken, total := 0, 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := w.img.At(x, y)
+			if c < 0 || c == art.Transparent {
+				continue
+			}
+			total++
+			cx, cy := w.x+x, w.y+y
+			if cx < 0 || cx >= Width || cy < 0 || cy >= Height || int(s.colors[cx+cy*Width]) != c {
+				broken++
+			}
+		}
+	}
+	return broken, total
+}
+
+func (w *Watch) sample(s *Server, now time.Time) (int, int) {
+	broken, total := w.damage(s)
+	w.samples++
+	w.damageSum += broken
+	if broken > w.maxDamage {
+		w.maxDamage = broken
+	}
+	if broken > 0 && w.damaged.IsZero() {
+		w.damaged = now
+	} else if broken == 0 && !w.damaged.IsZero() {
+		d := now.Sub(w.damaged)
+		w.restores++
+		w.restoreTime += d
+		w.damaged = time.Time{}
+		log.Infof("Watch %v restored in %v", w.path, d)
+	}
+	return broken, total
+}
+
+func (w *Watch) summary() {
+	if w.samples == 0 {
+		return
+	}
+	avgRestore := time.Duration(0)
+	if w.restores > 0 {
+		avgRestore = w.restoreTime / time.Duration(w.restores)
+	}
+	log.Infof("Watch %v summary: avg damage %.1f px, max damage %v px, %v restores, avg restore time %v",
+		w.path, float64(w.damageSum)/float64(w.samples), w.maxDamage, w.restores, avgRestore)
+}
+
+// Samples the watches every second until stop is closed, then logs a summary
+func (s *Server) RunWatches(watches []*Watch, stop chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastReport := time.Now()
+	for {
+		select {
+		case <-stop:
+			for _, w := range watches {
+				w.summary()
+			}
+			return
+		case now := <-ticker.C:
+			report := now.Sub(lastReport) >= reportInterval
+			for _, w := range watches {
+				broken, total := w.sample(s, now)
+				if report && total > 0 {
+					log.Infof("Watch %v: %v/%v pixels broken (%.1f%%)", w.path, broken, total, 100*float64(broken)/float64(total))
+				}
+			}
+			if report {
+				lastReport = now
+			}
+		}
+	}
+}
@@ -0,0 +1,352 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sim is a local canvas server that speaks the same HTTP + websocket protocol as josephg.com/sp
+package sim
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/log"
+	"github.com/xStrom/patriot/painter"
+	"github.com/xStrom/patriot/sp/protocol"
+)
+
+const (
+	Width  = art.CanvasWidth
+	Height = art.CanvasHeight
+
+	// Same limits as the real server
+	scoreWindow = painter.ScoreWindowSecs * time.Second
+
+	historySize   = 100000 // Edits kept around for clients resuming with ?from=
+	flushInterval = 100 * time.Millisecond
+	clientBuffer  = 256
+)
+
+type edit struct {
+	x       int
+	y       int
+	c       int
+	version int
+}
+
+type limit struct {
+	start time.Time
+	cost  int
+}
+
+type client struct {
+	send chan []byte
+}
+
+type Server struct {
+	lock     sync.Mutex
+	version  int
+	colors   []uint8
+	history  []edit
+	pending  []edit
+	clients  map[*client]bool
+	limits   map[string]*limit
+	keyframe []byte
+	kfVer    int
+	upgrader websocket.Upgrader
+	mux      *http.ServeMux
+}
+
+func New() *Server {
+	s := &Server{
+		version: 1,
+		colors:  make([]uint8, Width*Height), // All White
+		clients: map[*client]bool{},
+		limits:  map[string]*limit{},
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		mux: http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/sp/current", s.handleCurrent)
+	s.mux.HandleFunc("/sp/edit", s.handleEdit)
+	s.mux.HandleFunc("/sp/ws", s.handleWS)
+	go s.flushLoop()
+	return s
+}
+
+// Replaces the canvas contents with the provided keyframe PNG
+func (s *Server) LoadKeyframe(data []byte) error {
+	img := &art.Image{}
+	if err := img.ParseKeyframe(1, data, false); err != nil {
+		return errors.Wrap(err, "Failed to parse keyframe")
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for y := 0; y < Height; y++ {
+		for x := 0; x < Width; x++ {
+			c := img.At(x, y)
+			if c < 0 || c >= art.CurrentPalette().Len() {
+				c = art.White
+			}
+			s.colors[x+y*Width] = uint8(c)
+		}
+	}
+	s.version++
+	// Edits from before the keyframe must not reach anyone anymore
+	s.history = s.history[:0]
+	s.pending = s.pending[:0]
+	s.keyframe = nil
+	return nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Version returns the current canvas version
+func (s *Server) Version() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.version
+}
+
+// At returns the color at x, y or -1 if out of bounds
+func (s *Server) At(x, y int) int {
+	if x < 0 || x >= Width || y < 0 || y >= Height {
+		return -1
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return int(s.colors[x+y*Width])
+}
+
+// Draw performs an edit on behalf of who, applying the same rate limiting as the real server.
+// Returns the HTTP status code the request would get.
+func (s *Server) Draw(who string, x, y, c int) int {
+	if x < 0 || x >= Width || y < 0 || y >= Height || c < 0 || c >= art.CurrentPalette().Len() {
+		return http.StatusBadRequest
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	cost := painter.DrawCallCost(int(s.colors[x+y*Width]))
+	now := time.Now()
+	l := s.limits[who]
+	if l == nil || now.Sub(l.start) >= scoreWindow {
+		l = &limit{start: now}
+		s.limits[who] = l
+	}
+	if l.cost+cost > painter.ScorePerWindow {
+		return http.StatusForbidden
+	}
+	l.cost += cost
+
+	s.applyEdit(x, y, c)
+	return http.StatusOK
+}
+
+// Performs an edit without any rate limiting
+func (s *Server) edit(x, y, c int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.applyEdit(x, y, c)
+}
+
+func (s *Server) applyEdit(x, y, c int) {
+	s.version++
+	s.colors[x+y*Width] = uint8(c)
+	s.pending = append(s.pending, edit{x, y, c, s.version})
+}
+
+func (s *Server) handleCurrent(w http.ResponseWriter, r *http.Request) {
+	data, version, err := s.encodeKeyframe()
+	if err != nil {
+		log.Infof("Failed to encode keyframe: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Content-Version", strconv.Itoa(version))
+	w.Write(data)
+}
+
+func (s *Server) encodeKeyframe() ([]byte, int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// Pending edits are already part of the version
+	if s.keyframe != nil && s.kfVer == s.version {
+		return s.keyframe, s.kfVer, nil
+	}
+	img := image.NewPaletted(image.Rect(0, 0, Width, Height), art.ColorPalette())
+	copy(img.Pix, s.colors)
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		return nil, -1, err
+	}
+	s.keyframe = buf.Bytes()
+	s.kfVer = s.version
+	return s.keyframe, s.kfVer, nil
+}
+
+func (s *Server) handleEdit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	x, errX := strconv.Atoi(q.Get("x"))
+	y, errY := strconv.Atoi(q.Get("y"))
+	c, errC := strconv.Atoi(q.Get("c"))
+	if errX != nil || errY != nil || errC != nil {
+		http.Error(w, "Invalid edit", http.StatusBadRequest)
+		return
+	}
+	who, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		who = r.RemoteAddr
+	}
+	if status := s.Draw(who, x, y, c); status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+	}
+}
+
+func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	from, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from", http.StatusBadRequest)
+		return
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Infof("Failed to upgrade websocket: %v", err)
+		return
+	}
+	cl := &client{send: make(chan []byte, clientBuffer)}
+
+	s.lock.Lock()
+	if catchup, ok := s.catchup(from); !ok {
+		message, _ := protocol.Encode(protocol.Reload{})
+		cl.send <- message
+		close(cl.send)
+	} else {
+		if catchup != nil {
+			cl.send <- catchup
+		}
+		s.clients[cl] = true
+	}
+	s.lock.Unlock()
+
+	go func() {
+		// Drain reads so that close frames get handled
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				break
+			}
+		}
+		s.removeClient(cl)
+	}()
+
+	for message := range cl.send {
+		if err := conn.WriteMessage(websocket.BinaryMessage, message); err != nil {
+			break
+		}
+	}
+	s.removeClient(cl)
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.Close()
+}
+
+// Returns the edits a client needs to get from version from up to the last flush,
+// anything newer reaches the client with the next flush.
+// The second return value is false if the history doesn't reach back far enough,
+// or if from is newer than the canvas, e.g. for a client of a server that restarted.
+func (s *Server) catchup(from int) ([]byte, bool) {
+	if from > s.version {
+		return nil, false
+	}
+	flushed := s.version - len(s.pending)
+	if from >= flushed {
+		return nil, true
+	}
+	if len(s.history) == 0 || s.history[0].version > from+1 {
+		return nil, false
+	}
+	i := len(s.history)
+	for i > 0 && s.history[i-1].version > from {
+		i--
+	}
+	return encodeEdits(s.history[i:]), true
+}
+
+func (s *Server) removeClient(cl *client) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.clients[cl] {
+		delete(s.clients, cl)
+		close(cl.send)
+	}
+}
+
+func (s *Server) flushLoop() {
+	for range time.Tick(flushInterval) {
+		s.flush()
+	}
+}
+
+func (s *Server) flush() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.pending) == 0 {
+		return
+	}
+	message := encodeEdits(s.pending)
+	for cl := range s.clients {
+		select {
+		case cl.send <- message:
+		default:
+			log.Infof("Dropping slow websocket client")
+			delete(s.clients, cl)
+			close(cl.send)
+		}
+	}
+	s.history = append(s.history, s.pending...)
+	if len(s.history) > historySize {
+		s.history = append(s.history[:0], s.history[len(s.history)-historySize:]...)
+	}
+	s.pending = s.pending[:0]
+}
+
+// Encodes edits into a single message, the version being that of the last edit
+func encodeEdits(edits []edit) []byte {
+	m := &protocol.Edits{Version: edits[len(edits)-1].version, Edits: make([]protocol.Edit, len(edits))}
+	for i, e := range edits {
+		m.Edits[i] = protocol.Edit{X: e.x, Y: e.y, Color: e.c}
+	}
+	message, err := protocol.Encode(m)
+	if err != nil {
+		// Edits are validated when they're made and the version that could read as refresh is billions of edits away
+		panic(fmt.Sprintf("Failed to encode edits: %v", err))
+	}
+	return message
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sim
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/sp/protocol"
+)
+
+// Returns a server that only flushes when told to
+func newServer() *Server {
+	return &Server{version: 1, colors: make([]uint8, Width*Height)}
+}
+
+func TestCatchup(t *testing.T) {
+	s := newServer()
+	s.applyEdit(1, 1, art.Red)   // v2
+	s.applyEdit(2, 2, art.Black) // v3
+	s.flush()
+	s.applyEdit(3, 3, art.Green) // v4, not flushed yet
+
+	tests := []struct {
+		name  string
+		from  int
+		want  []int // Versions of the edits sent
+		valid bool
+	}{
+		{"up to date", 4, nil, true},
+		{"only pending edits missing", 3, nil, true},
+		{"flushed edits missing", 1, []int{2, 3}, true},
+		{"some flushed edits missing", 2, []int{3}, true},
+		{"newer than the canvas", 5, nil, false},
+	}
+	for _, tt := range tests {
+		message, ok := s.catchup(tt.from)
+		if ok != tt.valid {
+			t.Errorf("%v: got %v, want %v", tt.name, ok, tt.valid)
+			continue
+		}
+		if message == nil {
+			if tt.want != nil {
+				t.Errorf("%v: got no edits, want v%v", tt.name, tt.want)
+			}
+			continue
+		}
+		m, err := protocol.Decode(message, protocol.Strict)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		edits := m.(*protocol.Edits)
+		if len(edits.Edits) != len(tt.want) || edits.First() != tt.want[0] || edits.Version != tt.want[len(tt.want)-1] {
+			t.Errorf("%v: got v%v to v%v, want v%v", tt.name, edits.First(), edits.Version, tt.want)
+		}
+	}
+}
+
+func TestLoadKeyframeDropsPendingEdits(t *testing.T) {
+	s := newServer()
+	s.applyEdit(1, 1, art.Red) // Waiting for the next flush
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewPaletted(image.Rect(0, 0, Width, Height), art.ColorPalette())); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LoadKeyframe(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.pending) != 0 {
+		t.Errorf("%v edits from before the keyframe are still pending", len(s.pending))
+	}
+	if _, ok := s.catchup(1); ok {
+		t.Error("A client from before the keyframe can resume")
+	}
+}
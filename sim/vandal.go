@@ -0,0 +1,253 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sim
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/art/resource"
+	"github.com/xStrom/patriot/log"
+)
+
+// Fastest a vandal can edit in edits per second, keeping the ticker interval positive
+const MaxVandalRate = 1000
+
+// Vandal is an adversary that keeps editing the simulated canvas
+type Vandal interface {
+	Name() string
+	// Returns the next edit to perform, or false if there's nothing to do right now
+	Next(s *Server, rnd *rand.Rand) (x, y, c int, ok bool)
+}
+
+// A rectangle of the canvas, x1 and y1 exclusive
+type rect struct {
+	x0, y0, x1, y1 int
+}
+
+func (r rect) random(rnd *rand.Rand) (int, int) {
+	return r.x0 + rnd.Intn(r.x1-r.x0), r.y0 + rnd.Intn(r.y1-r.y0)
+}
+
+// Paints random pixels with random colors
+type scribbler struct {
+	area rect
+}
+
+func (v *scribbler) Name() string { return "scribbler" }
+
+func (v *scribbler) Next(s *Server, rnd *rand.Rand) (int, int, int, bool) {
+	x, y := v.area.random(rnd)
+	return x, y, rnd.Intn(art.CurrentPalette().Len()), true
+}
+
+// Paints over an area with a single color
+type eraser struct {
+	area  rect
+	color int
+}
+
+func (v *eraser) Name() string { return "eraser" }
+
+func (v *eraser) Next(s *Server, rnd *rand.Rand) (int, int, int, bool) {
+	// Probe a few random spots so that we mostly hit pixels that aren't erased yet
+	for i := 0; i < 32; i++ {
+		x, y := v.area.random(rnd)
+		if s.At(x, y) != v.color {
+			return x, y, v.color, true
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// Replaces one color with another
+type swapper struct {
+	area rect
+	from int
+	to   int
+}
+
+func (v *swapper) Name() string { return "swapper" }
+
+func (v *swapper) Next(s *Server, rnd *rand.Rand) (int, int, int, bool) {
+	for i := 0; i < 32; i++ {
+		x, y := v.area.random(rnd)
+		if s.At(x, y) == v.from {
+			return x, y, v.to, true
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// Another bot drawing its own art over ours, playing by the same rate limits as we do
+type competitor struct {
+	area rect
+	img  *art.Image
+}
+
+func (v *competitor) Name() string { return "competitor" }
+
+func (v *competitor) Next(s *Server, rnd *rand.Rand) (int, int, int, bool) {
+	for i := 0; i < 32; i++ {
+		x, y := v.area.random(rnd)
+		c := v.img.At(x-v.area.x0, y-v.area.y0)
+		if c >= 0 && c != art.Transparent && s.At(x, y) != c {
+			return x, y, c, true
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// Runs a vandal at the given rate of edits per second until stop is closed, rates past MaxVandalRate are capped
+func (s *Server) RunVandal(v Vandal, rate float64, seed int64, stop chan struct{}) {
+	if !(rate > 0) {
+		log.Infof("Not running vandal %v with rate %v", v.Name(), rate)
+		return
+	}
+	if rate > MaxVandalRate {
+		rate = MaxVandalRate
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	_, limited := v.(*competitor)
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	edits, rejected := 0, 0
+	for {
+		select {
+		case <-stop:
+			log.Infof("Vandal %v stopped after %v edits (%v rate limited)", v.Name(), edits, rejected)
+			return
+		case <-ticker.C:
+		}
+		x, y, c, ok := v.Next(s, rnd)
+		if !ok {
+			continue
+		}
+		if limited {
+			if s.Draw(v.Name(), x, y, c) == http.StatusForbidden {
+				rejected++
+				continue
+			}
+		} else {
+			// A crowd of vandals isn't bound by a single client's rate limit
+			s.edit(x, y, c)
+		}
+		edits++
+	}
+}
+
+// Parses a vandal spec of the form kind[:key=value,...], e.g.
+//
+//	scribbler:rate=5
+//	eraser:resource=data/estville2.png,x=735,y=875,rate=2
+//	swapper:x=700,y=850,w=200,h=150,from=white,to=black
+//	competitor:resource=data/dota.png,x=735,y=875
+//
+// Returns the vandal and its rate in edits per second, which has to be above 0 and at most MaxVandalRate.
+func ParseVandal(spec string) (Vandal, float64, error) {
+	kind := spec
+	opts := map[string]string{}
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind = spec[:i]
+		for _, kv := range strings.Split(spec[i+1:], ",") {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				return nil, 0, errors.Errorf("Invalid vandal option %q in %q", kv, spec)
+			}
+			opts[parts[0]] = parts[1]
+		}
+	}
+
+	rate := 1.0
+	if r, ok := opts["rate"]; ok {
+		var err error
+		if rate, err = strconv.ParseFloat(r, 64); err != nil || !(rate > 0 && rate <= MaxVandalRate) {
+			return nil, 0, errors.Errorf("Invalid vandal rate %q in %q", r, spec)
+		}
+	}
+	area, img, err := parseArea(opts)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "Invalid vandal area in %q", spec)
+	}
+
+	switch kind {
+	case "scribbler":
+		return &scribbler{area}, rate, nil
+	case "eraser":
+		color := art.White
+		if name, ok := opts["color"]; ok {
+			if color, err = parseColor(name); err != nil {
+				return nil, 0, errors.Wrapf(err, "Invalid vandal in %q", spec)
+			}
+		}
+		return &eraser{area, color}, rate, nil
+	case "swapper":
+		from, err := parseColor(opts["from"])
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "Invalid vandal in %q", spec)
+		}
+		to, err := parseColor(opts["to"])
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "Invalid vandal in %q", spec)
+		}
+		return &swapper{area, from, to}, rate, nil
+	case "competitor":
+		if img == nil {
+			return nil, 0, errors.Errorf("Competitor needs a resource in %q", spec)
+		}
+		return &competitor{area, img}, rate, nil
+	}
+	return nil, 0, errors.Errorf("Unknown vandal kind %q", kind)
+}
+
+// Area is either x,y + resource or x,y,w,h, defaulting to the whole canvas
+func parseArea(opts map[string]string) (rect, *art.Image, error) {
+	ints := map[string]int{"x": 0, "y": 0, "w": Width, "h": Height}
+	for k := range ints {
+		if v, ok := opts[k]; ok {
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				return rect{}, nil, errors.Errorf("Invalid %v: %v", k, v)
+			}
+			ints[k] = i
+		}
+	}
+	var img *art.Image
+	if path, ok := opts["resource"]; ok {
+		var err error
+		if img, err = resource.LoadImage(path); err != nil {
+			return rect{}, nil, err
+		}
+		ints["w"], ints["h"] = img.Dimensions()
+	}
+	r := rect{ints["x"], ints["y"], ints["x"] + ints["w"], ints["y"] + ints["h"]}
+	if r.x0 < 0 || r.y0 < 0 || r.x1 > Width || r.y1 > Height || r.x0 >= r.x1 || r.y0 >= r.y1 {
+		return rect{}, nil, errors.Errorf("Area out of bounds: %v,%v - %v,%v", r.x0, r.y0, r.x1, r.y1)
+	}
+	return r, img, nil
+}
+
+func parseColor(name string) (int, error) {
+	if c, ok := art.ColorByName(name); ok && c != art.Transparent {
+		return c, nil
+	}
+	return -1, errors.Errorf("Unknown color %q", name)
+}
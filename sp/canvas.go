@@ -0,0 +1,54 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sp
+
+import (
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultBackend = "josephg"
+	DefaultURL     = "https://josephg.com/sp"
+)
+
+// Canvas is a pixel canvas the bot can read from and draw on
+type Canvas interface {
+	// Returns the current keyframe PNG and its version
+	FetchImage() ([]byte, int, error)
+	// Returns an error and the HTTP status code of the request
+	DrawPixel(x, y, c int) (error, int)
+	// Starts streaming realtime messages for all edits after version from
+	Subscribe(from int) (Subscription, error)
+}
+
+// Subscription is a stream of raw realtime messages
+type Subscription interface {
+	ReadMessage() ([]byte, error)
+	// Asks the server to end the stream, ReadMessage will return an error once it does
+	Shutdown() error
+	Close() error
+}
+
+var backends = map[string]func(baseURL string) (Canvas, error){
+	"josephg": func(baseURL string) (Canvas, error) { return NewJosephG(baseURL) },
+}
+
+func New(backend, baseURL string) (Canvas, error) {
+	b, ok := backends[backend]
+	if !ok {
+		return nil, errors.Errorf("Unknown canvas backend: %v", backend)
+	}
+	return b(baseURL)
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package protocol encodes and decodes the messages the canvas server sends over the websocket
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// Limits of what fits in an encoded edit
+const (
+	MaxX     = 1<<10 - 1
+	MaxY     = 1<<10 - 1
+	MaxColor = 1<<4 - 1
+)
+
+const (
+	versionSize = 4
+	editSize    = 3
+)
+
+// How Decode treats bytes after the last whole edit
+type Mode int
+
+const (
+	Strict  Mode = iota // They're an error
+	Lenient             // They're kept in Edits.Trailing
+)
+
+// Message is one of Reload, Refresh or Edits
+type Message interface {
+	isMessage()
+}
+
+// The server can't catch us up, the keyframe has to be fetched again
+type Reload struct{}
+
+// The server wants clients to refresh, handled like Reload
+type Refresh struct{}
+
+// A batch of edits in the order they were made
+type Edits struct {
+	Version  int // Version of the last edit, every edit before it has the previous version
+	Edits    []Edit
+	Trailing []byte // Bytes after the last whole edit, only in Lenient mode
+}
+
+type Edit struct {
+	X     int
+	Y     int
+	Color int
+}
+
+func (Reload) isMessage()  {}
+func (Refresh) isMessage() {}
+func (*Edits) isMessage()  {}
+
+// Returns the version of the first edit of the batch.
+// The server numbers edits one by one and sends them in order, so the edits of a batch always have consecutive versions
+// ending at Version. Gap detection relies on this, a batch with holes in it can't be told apart from missed edits.
+func (m *Edits) First() int {
+	return m.Version - len(m.Edits) + 1
+}
+
+var (
+	reloadMessage  = []byte("reload")
+	refreshMessage = []byte("refresh")
+)
+
+func Decode(data []byte, mode Mode) (Message, error) {
+	switch {
+	case bytes.Equal(data, reloadMessage):
+		return Reload{}, nil
+	case bytes.Equal(data, refreshMessage):
+		return Refresh{}, nil
+	case len(data) < versionSize+editSize:
+		return nil, errors.Errorf("Unknown message: %v", data)
+	}
+	n := (len(data) - versionSize) / editSize
+	end := versionSize + n*editSize
+	if end != len(data) && mode == Strict {
+		return nil, errors.Errorf("Unexpected %v bytes after %v edits", len(data)-end, n)
+	}
+	m := &Edits{Version: int(binary.LittleEndian.Uint32(data)), Edits: make([]Edit, n)}
+	for i := range m.Edits {
+		m.Edits[i] = DecodeEdit(data[versionSize+i*editSize:])
+	}
+	if end != len(data) {
+		m.Trailing = append([]byte(nil), data[end:]...)
+	}
+	return m, nil
+}
+
+func Encode(m Message) ([]byte, error) {
+	switch m := m.(type) {
+	case Reload, *Reload:
+		return append([]byte(nil), reloadMessage...), nil
+	case Refresh, *Refresh:
+		return append([]byte(nil), refreshMessage...), nil
+	case *Edits:
+		if len(m.Edits) == 0 {
+			return nil, errors.New("Edits need at least one edit")
+		}
+		data := make([]byte, versionSize, versionSize+editSize*len(m.Edits))
+		binary.LittleEndian.PutUint32(data, uint32(m.Version))
+		for _, e := range m.Edits {
+			if e.X < 0 || e.X > MaxX || e.Y < 0 || e.Y > MaxY || e.Color < 0 || e.Color > MaxColor {
+				return nil, errors.Errorf("Edit %v:%v - %v doesn't fit the protocol", e.X, e.Y, e.Color)
+			}
+			data = append(data, EncodeEdit(e)...)
+		}
+		if bytes.Equal(data, refreshMessage) {
+			// A single edit at version 1919313266 is exactly the 7 bytes of refresh
+			return nil, errors.New("Edits would be decoded as refresh")
+		}
+		return data, nil
+	}
+	return nil, errors.Errorf("Unknown message type %T", m)
+}
+
+// Decodes the first 3 bytes of data
+func DecodeEdit(data []byte) Edit {
+	xx := uint(data[0])
+	yx := uint(data[1])
+	cy := uint(data[2])
+
+	x := xx | ((yx & 0x3) << 8)
+	y := (yx >> 2) | ((cy & 0xf) << 6)
+	color := cy >> 4
+
+	return Edit{int(x), int(y), int(color)}
+}
+
+// Packs the edit into 3 bytes, bits that don't fit are dropped
+func EncodeEdit(e Edit) []byte {
+	return []byte{
+		byte(e.X),
+		byte((e.X>>8)&0x3 | (e.Y&0x3f)<<2),
+		byte((e.Y>>6)&0xf | (e.Color&0xf)<<4),
+	}
+}
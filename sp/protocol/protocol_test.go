@@ -0,0 +1,120 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// Version 5, with edits 1:2 - 3 and 1023:1023 - 15
+var twoEdits = []byte{5, 0, 0, 0, 1, 2 << 2, 3 << 4, 0xff, 0xff, 0xff}
+
+func TestDecode(t *testing.T) {
+	edits := []Edit{{1, 2, 3}, {1023, 1023, 15}}
+	tests := []struct {
+		name string
+		data []byte
+		mode Mode
+		want Message
+		err  bool
+	}{
+		{"reload", []byte("reload"), Strict, Reload{}, false},
+		{"refresh", []byte("refresh"), Strict, Refresh{}, false},
+		{"edits", twoEdits, Strict, &Edits{Version: 5, Edits: edits}, false},
+		{"trailing partial edit strict", append(twoEdits[:10:10], 0xaa, 0xbb), Strict, nil, true},
+		{"trailing partial edit lenient", append(twoEdits[:10:10], 0xaa, 0xbb), Lenient, &Edits{Version: 5, Edits: edits, Trailing: []byte{0xaa, 0xbb}}, false},
+		{"too short", []byte{5, 0, 0, 0, 1}, Lenient, nil, true},
+		{"unknown text", []byte("reloa"), Lenient, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := Decode(tt.data, tt.mode)
+		if (err != nil) != tt.err {
+			t.Errorf("%v: got error %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Message
+		want []byte
+	}{
+		{"reload", Reload{}, []byte("reload")},
+		{"refresh", &Refresh{}, []byte("refresh")},
+		{"edits", &Edits{Version: 5, Edits: []Edit{{1, 2, 3}, {1023, 1023, 15}}}, twoEdits},
+		{"no edits", &Edits{Version: 5}, nil},
+		{"x out of range", &Edits{Version: 5, Edits: []Edit{{MaxX + 1, 0, 0}}}, nil},
+		{"negative y", &Edits{Version: 5, Edits: []Edit{{0, -1, 0}}}, nil},
+		{"color out of range", &Edits{Version: 5, Edits: []Edit{{0, 0, MaxColor + 1}}}, nil},
+		{"reads as refresh", &Edits{Version: 1919313266, Edits: []Edit{{869, 540, 6}}}, nil},
+		{"nil", nil, nil},
+	}
+	for _, tt := range tests {
+		got, err := Encode(tt.m)
+		if (err != nil) != (tt.want == nil) {
+			t.Errorf("%v: got error %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte("reload"))
+	f.Add([]byte("refresh"))
+	f.Add(twoEdits)
+	f.Add(append(twoEdits[:10:10], 0xaa))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, mode := range []Mode{Strict, Lenient} {
+			m, err := Decode(data, mode)
+			if err != nil {
+				continue
+			}
+			encoded, err := Encode(m)
+			if err != nil && bytes.HasPrefix(data, []byte("refresh")) {
+				// Edits that would read as refresh can't be encoded
+				continue
+			}
+			if err != nil {
+				t.Fatalf("Failed to encode %#v decoded from %v: %v", m, data, err)
+			}
+			again, err := Decode(encoded, Strict)
+			if err != nil {
+				t.Fatalf("Failed to decode %v encoded from %#v: %v", encoded, m, err)
+			}
+			// Trailing bytes aren't part of any edit, so they don't get encoded
+			if edits, ok := m.(*Edits); ok {
+				trimmed := *edits
+				trimmed.Trailing = nil
+				m = &trimmed
+				if !bytes.Equal(encoded, data[:len(data)-len(edits.Trailing)]) {
+					t.Fatalf("Encoded %v as %v", data, encoded)
+				}
+			}
+			if !reflect.DeepEqual(again, m) {
+				t.Fatalf("Decoded %v as %#v, then %#v", data, m, again)
+			}
+		}
+	})
+}
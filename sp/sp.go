@@ -19,10 +19,13 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 
 	"github.com/xStrom/patriot/log"
@@ -48,9 +51,34 @@ func FetchImageFromFile() ([]byte, error) {
 	return ioutil.ReadFile("snapshots/current1.png")
 }
 
-func FetchImage() ([]byte, int, error) {
+// JosephG talks to a canvas server that speaks the josephg.com/sp HTTP + websocket protocol
+type JosephG struct {
+	baseURL string
+	wsURL   string
+}
+
+// NewJosephG returns a backend for the server at baseURL, e.g. https://josephg.com/sp
+func NewJosephG(baseURL string) (*JosephG, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse base URL")
+	}
+	ws := *u
+	switch u.Scheme {
+	case "https":
+		ws.Scheme = "wss"
+	case "http":
+		ws.Scheme = "ws"
+	default:
+		return nil, errors.Errorf("Unsupported base URL scheme: %v", u.Scheme)
+	}
+	ws.Path += "/ws"
+	return &JosephG{baseURL: u.String(), wsURL: ws.String()}, nil
+}
+
+func (j *JosephG) FetchImage() ([]byte, int, error) {
 	t := time.Now()
-	req, err := http.NewRequest("GET", "https://josephg.com/sp/current", nil)
+	req, err := http.NewRequest("GET", j.baseURL+"/current", nil)
 	if err != nil {
 		return nil, -1, errors.Wrap(err, "Failed creating request")
 	}
@@ -59,6 +87,7 @@ func FetchImage() ([]byte, int, error) {
 	if err != nil {
 		return nil, -1, errors.Wrap(err, "Failed performing request")
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		return nil, -1, errors.Errorf("Got non-OK status: %v", resp.StatusCode)
 	}
@@ -85,9 +114,9 @@ func FetchImage() ([]byte, int, error) {
 	}
 }
 
-func DrawPixel(x, y, c int) (error, int) {
+func (j *JosephG) DrawPixel(x, y, c int) (error, int) {
 	t := time.Now()
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://josephg.com/sp/edit?x=%v&y=%v&c=%v", x, y, c), nil)
+	req, err := http.NewRequest("POST", fmt.Sprintf("%v/edit?x=%v&y=%v&c=%v", j.baseURL, x, y, c), nil)
 	if err != nil {
 		return errors.Wrap(err, "Failed creating request"), -1
 	}
@@ -96,6 +125,7 @@ func DrawPixel(x, y, c int) (error, int) {
 	if err != nil {
 		return errors.Wrap(err, "Failed performing request"), -1
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		return errors.Errorf("Got non-OK status: %v", resp.StatusCode), resp.StatusCode
 	}
@@ -107,3 +137,32 @@ func DrawPixel(x, y, c int) (error, int) {
 	log.Infof("Drew: %v - %v - %v [%dms]", x, y, c, time.Since(t)/time.Millisecond)
 	return nil, resp.StatusCode
 }
+
+func (j *JosephG) Subscribe(from int) (Subscription, error) {
+	u := fmt.Sprintf("%v?from=%v", j.wsURL, from)
+	log.Infof("connecting to %s", u)
+	c, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &wsSubscription{c}, nil
+}
+
+type wsSubscription struct {
+	c *websocket.Conn
+}
+
+func (s *wsSubscription) ReadMessage() ([]byte, error) {
+	_, message, err := s.c.ReadMessage()
+	return message, err
+}
+
+func (s *wsSubscription) Shutdown() error {
+	// To cleanly close a connection, a client should send a close
+	// frame and wait for the server to close the connection.
+	return s.c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
+
+func (s *wsSubscription) Close() error {
+	return s.c.Close()
+}
@@ -0,0 +1,218 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sptest provides an in-memory sp.Canvas so that the bot can be tested without a canvas server
+package sptest
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/sp"
+	"github.com/xStrom/patriot/sp/protocol"
+)
+
+// Messages a subscription buffers before it gets dropped
+const subscriptionBuffer = 1024
+
+type edit struct {
+	protocol.Edit
+	version int
+}
+
+// Canvas is an in-memory canvas that applies every draw right away and streams it to its subscriptions
+type Canvas struct {
+	lock       sync.Mutex
+	version    int
+	colors     []uint8
+	history    []edit
+	subs       map[*Subscription]bool
+	subscribed []int
+	draws      []art.Pixel
+}
+
+var _ sp.Canvas = (*Canvas)(nil)
+
+// Returns a blank canvas at version 1
+func NewCanvas() *Canvas {
+	return &Canvas{
+		version: 1,
+		colors:  make([]uint8, art.CanvasWidth*art.CanvasHeight), // All White
+		subs:    map[*Subscription]bool{},
+	}
+}
+
+func (c *Canvas) FetchImage() ([]byte, int, error) {
+	c.lock.Lock()
+	img := image.NewPaletted(image.Rect(0, 0, art.CanvasWidth, art.CanvasHeight), art.ColorPalette())
+	copy(img.Pix, c.colors)
+	version := c.version
+	c.lock.Unlock()
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		return nil, -1, errors.Wrap(err, "Failed to encode keyframe")
+	}
+	return buf.Bytes(), version, nil
+}
+
+// Applies the edit without any rate limiting
+func (c *Canvas) DrawPixel(x, y, color int) (error, int) {
+	if x < 0 || x >= art.CanvasWidth || y < 0 || y >= art.CanvasHeight || color < 0 || color >= art.CurrentPalette().Len() {
+		return errors.Errorf("Invalid edit %v:%v - %v", x, y, color), http.StatusBadRequest
+	}
+	c.lock.Lock()
+	c.draws = append(c.draws, art.Pixel{X: x, Y: y, C: color})
+	c.lock.Unlock()
+	c.Edit(x, y, color)
+	return nil, http.StatusOK
+}
+
+// Applies an edit made by someone else than the bot and streams it to the subscriptions
+func (c *Canvas) Edit(x, y, color int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.version++
+	c.colors[x+y*art.CanvasWidth] = uint8(color)
+	e := edit{protocol.Edit{X: x, Y: y, Color: color}, c.version}
+	c.history = append(c.history, e)
+	c.broadcast(encodeEdits([]edit{e}))
+}
+
+// Streams a raw message to the subscriptions without changing the canvas, e.g. to fake lost edits
+func (c *Canvas) Send(message []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.broadcast(message)
+}
+
+// Starts streaming, first with every edit after version from
+func (c *Canvas) Subscribe(from int) (sp.Subscription, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.subscribed = append(c.subscribed, from)
+	s := &Subscription{canvas: c, messages: make(chan []byte, subscriptionBuffer), done: make(chan struct{})}
+	i := len(c.history)
+	for i > 0 && c.history[i-1].version > from {
+		i--
+	}
+	if i < len(c.history) {
+		s.messages <- encodeEdits(c.history[i:])
+	}
+	c.subs[s] = true
+	return s, nil
+}
+
+// Returns the color at x, y
+func (c *Canvas) At(x, y int) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return int(c.colors[x+y*art.CanvasWidth])
+}
+
+func (c *Canvas) Version() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.version
+}
+
+// Returns the pixels drawn with DrawPixel so far
+func (c *Canvas) Draws() []art.Pixel {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]art.Pixel(nil), c.draws...)
+}
+
+// Returns the version every Subscribe call so far resumed from
+func (c *Canvas) Subscribed() []int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]int(nil), c.subscribed...)
+}
+
+// Must be called with the lock held
+func (c *Canvas) broadcast(message []byte) {
+	for s := range c.subs {
+		select {
+		case s.messages <- message:
+		default:
+			// Too slow, the stream ends and the client has to resume
+			c.remove(s)
+		}
+	}
+}
+
+// Must be called with the lock held
+func (c *Canvas) remove(s *Subscription) {
+	if c.subs[s] {
+		delete(c.subs, s)
+		close(s.messages)
+	}
+}
+
+func encodeEdits(edits []edit) []byte {
+	m := &protocol.Edits{Version: edits[len(edits)-1].version, Edits: make([]protocol.Edit, len(edits))}
+	for i, e := range edits {
+		m.Edits[i] = e.Edit
+	}
+	message, err := protocol.Encode(m)
+	if err != nil {
+		// DrawPixel validates edits and the canvas fits in the protocol
+		panic(err)
+	}
+	return message
+}
+
+// Subscription is a stream of a Canvas that ends once it's shut down or closed
+type Subscription struct {
+	canvas   *Canvas
+	messages chan []byte
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (s *Subscription) ReadMessage() ([]byte, error) {
+	select {
+	case <-s.done:
+		return nil, io.EOF
+	default:
+	}
+	select {
+	case message, ok := <-s.messages:
+		if !ok {
+			return nil, io.EOF
+		}
+		return message, nil
+	case <-s.done:
+		return nil, io.EOF
+	}
+}
+
+func (s *Subscription) Shutdown() error {
+	return s.Close()
+}
+
+func (s *Subscription) Close() error {
+	s.once.Do(func() { close(s.done) })
+	s.canvas.lock.Lock()
+	s.canvas.remove(s)
+	s.canvas.lock.Unlock()
+	return nil
+}
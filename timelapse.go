@@ -0,0 +1,263 @@
+// Copyright 2017 Kaur Kuut
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/config"
+	"github.com/xStrom/patriot/log"
+	"github.com/xStrom/patriot/record"
+	"github.com/xStrom/patriot/sp/protocol"
+)
+
+func timelapseCommand(args []string) {
+	fs := flag.NewFlagSet("timelapse", flag.ExitOnError)
+	recordPath := fs.String("record", "", "Log written with patriot -record to render")
+	snapshots := fs.String("snapshots", "", "Directory of canvas pngs to render in name order, instead of a log")
+	interval := fs.Duration("interval", time.Minute, "Recorded time between frames of a log")
+	crop := fs.String("crop", "", "Area to render as x,y,w,h, defaults to the whole canvas")
+	resourceName := fs.String("resource", "", "Name of a resource in -resources to crop to")
+	resources := fs.String("resources", "resources.json", "Config file to look up -resource in")
+	scale := fs.Int("scale", 1, "How many times to enlarge the frames")
+	format := fs.String("format", "gif", "gif for an animated gif, png for a numbered png per frame (APNG isn't supported)")
+	delay := fs.Duration("delay", 100*time.Millisecond, "How long every frame of the gif is shown")
+	maxMem := fs.Int("maxmem", 1024, "Megabytes the gif frames may take, as every frame is kept in memory until the gif is written. Each takes width*height*scale*scale bytes, pngs are written as they are rendered.")
+	out := fs.String("out", "", "Where to write the gif, or the directory for the pngs, defaults to timelapse.gif or timelapse/")
+	palette := fs.String("palette", "", "Palette of the canvas, see patriot -palette")
+	fs.Parse(args)
+	usePalette(*palette)
+
+	if (*recordPath == "") == (*snapshots == "") {
+		log.Infof("Need either -record or -snapshots")
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *scale < 1 {
+		log.Infof("Scale has to be at least 1")
+		os.Exit(2)
+	}
+	if *format != "gif" && *format != "png" {
+		log.Infof("Unknown format %q", *format)
+		os.Exit(2)
+	}
+	if *out == "" {
+		*out = "timelapse"
+		if *format == "gif" {
+			*out += ".gif"
+		}
+	}
+	area := image.Rect(0, 0, art.CanvasWidth, art.CanvasHeight)
+	if *crop != "" || *resourceName != "" {
+		var err error
+		if area, err = cropArea(*crop, *resourceName, *resources); err != nil {
+			log.Infof("Invalid crop: %v", err)
+			os.Exit(2)
+		}
+	}
+
+	if *format == "png" {
+		if err := os.MkdirAll(*out, 0755); err != nil {
+			log.Infof("Failed to create %v: %v", *out, err)
+			os.Exit(1)
+		}
+	}
+	frameSize := area.Dx() * area.Dy() * (*scale) * (*scale)
+	var frames []*image.Paletted
+	count := 0
+	render := func(img *art.Image) error {
+		// Upscaling also moves the cropped area to the origin, which gif frames need
+		frame := upscale(img.Paletted(art.CurrentPalette()).SubImage(area).(*image.Paletted), *scale)
+		count++
+		if *format == "png" {
+			return writePNG(filepath.Join(*out, fmt.Sprintf("frame%04d.png", count)), frame)
+		}
+		if count*frameSize > *maxMem<<20 {
+			return errors.Errorf("The gif frames would take more than %vMB, use a larger -interval, a smaller -crop or -scale, -format png or a larger -maxmem", *maxMem)
+		}
+		frames = append(frames, frame)
+		return nil
+	}
+	var err error
+	if *recordPath != "" {
+		err = renderRecord(*recordPath, *interval, render)
+	} else {
+		err = renderSnapshots(*snapshots, render)
+	}
+	if err != nil {
+		log.Infof("Failed to render timelapse: %v", err)
+		os.Exit(1)
+	}
+
+	if *format == "gif" {
+		if err := writeGIF(*out, frames, *delay); err != nil {
+			log.Infof("Failed to write timelapse: %v", err)
+			os.Exit(1)
+		}
+	}
+	log.Infof("Wrote %v frames of %vx%v to %v", count, area.Dx()*(*scale), area.Dy()*(*scale), *out)
+}
+
+// Parses x,y,w,h or looks up the area of the named resource
+func cropArea(crop, name, resources string) (image.Rectangle, error) {
+	if name != "" {
+		cfg, err := config.Load(resources)
+		if err != nil {
+			return image.Rectangle{}, err
+		}
+		for _, rc := range cfg.Resources {
+			if rc.Name == name {
+				return rc.Bounds()
+			}
+		}
+		return image.Rectangle{}, errors.Errorf("No resource named %q in %v", name, resources)
+	}
+	parts := strings.Split(crop, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, errors.Errorf("%q isn't x,y,w,h", crop)
+	}
+	var v [4]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return image.Rectangle{}, errors.Errorf("%q isn't x,y,w,h", crop)
+		}
+		v[i] = n
+	}
+	area := image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3])
+	if v[2] <= 0 || v[3] <= 0 || !area.In(image.Rect(0, 0, art.CanvasWidth, art.CanvasHeight)) {
+		return image.Rectangle{}, errors.Errorf("%v isn't within the canvas", area)
+	}
+	return area, nil
+}
+
+// Replays the log, rendering the canvas every interval of recorded time and once more at the end
+func renderRecord(path string, interval time.Duration, render func(*art.Image) error) error {
+	r, err := record.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if interval <= 0 {
+		return errors.New("Interval has to be positive")
+	}
+	img := &art.Image{}
+	var next time.Time
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if next.IsZero() && rec.Kind != record.Keyframe {
+			// Edits before the first keyframe have nothing to apply to
+			continue
+		}
+		for !next.IsZero() && !rec.Time.Before(next) {
+			if err := render(img); err != nil {
+				return err
+			}
+			next = next.Add(interval)
+		}
+		switch rec.Kind {
+		case record.Keyframe:
+			if err := img.ParseKeyframe(rec.Version, rec.Data, false); err != nil {
+				return errors.Wrapf(err, "Failed to parse keyframe recorded at %v", rec.Time)
+			}
+			if next.IsZero() {
+				next = rec.Time
+				if err := render(img); err != nil {
+					return err
+				}
+				next = next.Add(interval)
+			}
+		case record.Message:
+			m, err := protocol.Decode(rec.Data, protocol.Lenient)
+			if err != nil {
+				continue
+			}
+			if edits, ok := m.(*protocol.Edits); ok {
+				// Same as realtime, edits the image already has are skipped
+				last := img.Version()
+				for i, e := range edits.Edits {
+					if v := edits.First() + i; v > last {
+						img.UpdatePixel(e.X, e.Y, e.Color, v)
+					}
+				}
+			}
+		}
+	}
+	if next.IsZero() {
+		return errors.Errorf("%v has no keyframe", path)
+	}
+	return render(img)
+}
+
+func renderSnapshots(dir string, render func(*art.Image) error) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.png"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return errors.Errorf("No pngs in %v", dir)
+	}
+	sort.Strings(paths)
+	for i, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrap(err, "Failed to read snapshot")
+		}
+		img := &art.Image{}
+		if err := img.ParseKeyframe(i+1, data, false); err != nil {
+			return errors.Wrapf(err, "Failed to parse %v", path)
+		}
+		if err := render(img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeGIF(path string, frames []*image.Paletted, delay time.Duration) error {
+	g := &gif.GIF{Image: frames, Delay: make([]int, len(frames))}
+	for i := range g.Delay {
+		g.Delay[i] = int(delay / (10 * time.Millisecond))
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create file")
+	}
+	if err := gif.EncodeAll(f, g); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "Failed to encode %v", path)
+	}
+	return f.Close()
+}
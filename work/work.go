@@ -16,8 +16,10 @@ package work
 
 import (
 	"sync"
+	"time"
 
 	"github.com/xStrom/patriot/art"
+	"github.com/xStrom/patriot/config"
 	"github.com/xStrom/patriot/log"
 	"github.com/xStrom/patriot/painter"
 	"github.com/xStrom/patriot/realtime"
@@ -25,13 +27,13 @@ import (
 	"github.com/xStrom/patriot/work/shutdown"
 )
 
-func Work(wg *sync.WaitGroup) {
-	img := &art.Image{}
-
+// Keeps img up to date with the canvas and has the painter defend the resources on it until shutdown
+func Work(wg *sync.WaitGroup, img *art.Image, canvas sp.Canvas, cfg *config.Config) {
 	log.Infof("Launching painter ...")
 	wg.Add(1)
-	go painter.Work(wg, img)
+	go painter.Work(wg, img, canvas, cfg)
 
+	result := realtime.Reload
 	for {
 		shutdown.ShutdownLock.RLock()
 		if shutdown.Shutdown {
@@ -42,16 +44,24 @@ func Work(wg *sync.WaitGroup) {
 		}
 		shutdown.ShutdownLock.RUnlock()
 
-		UpdateImage(img)
+		// After a disconnect or a gap the server replays what we missed, only a reload needs a new keyframe
+		if result == realtime.Reload {
+			UpdateImage(img, canvas)
+		}
 		wg.Add(1)
-		realtime.Realtime(wg, img)
+		result = realtime.Realtime(wg, img, canvas)
 	}
 }
 
-func UpdateImage(img *art.Image) {
+func UpdateImage(img *art.Image, canvas sp.Canvas) {
+	backoff := realtime.NewBackoff(realtime.BackoffBase, realtime.BackoffMax)
 start:
+	if delay := backoff.Next(); delay > 0 {
+		log.Infof("Retrying in %v ..", delay)
+		time.Sleep(delay)
+	}
 	log.Infof("Fetching image ..")
-	data, version, err := sp.FetchImage()
+	data, version, err := canvas.FetchImage()
 	if err != nil {
 		log.Infof("Failed to fetch image: %v", err)
 		goto start